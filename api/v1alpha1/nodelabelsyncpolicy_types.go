@@ -0,0 +1,272 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeLabelSyncPolicySpec defines how ARM tags and node labels are synced.
+// Fields left empty are defaulted the same way as the options ConfigMap.
+type NodeLabelSyncPolicySpec struct {
+	// NodeSelector selects the nodes this policy applies to. A policy with
+	// neither a node selector nor a resource name pattern applies to all nodes.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// ResourceNamePattern is a glob matched against the name of the VMSS or VM
+	// the node runs on, ex: "aks-gpupool-*".
+	// +optional
+	ResourceNamePattern string `json:"resourceNamePattern,omitempty"`
+
+	// Priority decides which policy is used when several match a node. The highest
+	// priority wins, and policies with equal priority are ordered by name.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// SyncDirection is the direction of synchronization. Default is arm-to-node.
+	// +kubebuilder:validation:Enum=arm-to-node;node-to-arm;two-way
+	// +optional
+	SyncDirection string `json:"syncDirection,omitempty"`
+
+	// LabelPrefix is the node label prefix. Default is azure.tags. An empty prefix is permitted.
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	LabelPrefix *string `json:"labelPrefix,omitempty"`
+
+	// TagPrefix is the ARM tag prefix used for node-to-arm sync. Default is node.labels.
+	// +optional
+	TagPrefix *string `json:"tagPrefix,omitempty"`
+
+	// ConflictPolicy is the policy for conflicting tag/label values. Default is arm-precedence.
+	// +kubebuilder:validation:Enum=arm-precedence;node-precedence;ignore
+	// +optional
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+
+	// TagTarget is whether ARM tags are synced to node labels, annotations or both. Annotations
+	// keep tag values that aren't valid label values. Default is labels.
+	// +kubebuilder:validation:Enum=labels;annotations;both
+	// +optional
+	TagTarget string `json:"tagTarget,omitempty"`
+
+	// LabelAggregation is how the labels of all nodes on a VMSS are combined before
+	// they are written as tags (node-to-arm and two-way sync). Default is any.
+	// +kubebuilder:validation:Enum=unanimous;majority;any;leader
+	// +optional
+	LabelAggregation string `json:"labelAggregation,omitempty"`
+
+	// InstanceTags is whether tags on the VMSS instance a node runs on are synced to the node along
+	// with the scale set's tags (arm-to-node and two-way sync), and which take precedence when both
+	// have a tag. Default is ignore.
+	// +kubebuilder:validation:Enum=ignore;instance-precedence;scale-set-precedence
+	// +optional
+	InstanceTags string `json:"instanceTags,omitempty"`
+
+	// PlatformLabelPrefix enables labels with Azure platform metadata of the VM or VMSS, such as its
+	// size, priority and image, under the given prefix, ex: azure.platform. Disabled when empty.
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	PlatformLabelPrefix string `json:"platformLabelPrefix,omitempty"`
+
+	// ResourceGroupFilter limits syncing to nodes in a single resource group. Default is none.
+	// +optional
+	ResourceGroupFilter string `json:"resourceGroupFilter,omitempty"`
+
+	// MinSyncPeriod is the minimum time between syncs of a node, ex: "5m" or "2h30m". Default is 5m.
+	// +kubebuilder:validation:Pattern=^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$
+	// +optional
+	MinSyncPeriod string `json:"minSyncPeriod,omitempty"`
+
+	// Transform changes ARM tag names and values to fit node label syntax. Without it, tags that
+	// aren't valid labels are skipped and long names are cut off.
+	// +optional
+	Transform *TagTransform `json:"transform,omitempty"`
+
+	// CollisionPolicy is how tags that map to the same label name are resolved: skip all of them,
+	// hash each label name with its tag name, or sync only the first tag by name. Default is skip.
+	// +kubebuilder:validation:Enum=skip;hash;first
+	// +optional
+	CollisionPolicy string `json:"collisionPolicy,omitempty"`
+
+	// Taints maps ARM tags to node taints (arm-to-node and two-way sync). Taints added by
+	// the operator are removed when their tag is removed, other taints are left alone.
+	// +optional
+	Taints []TagTaint `json:"taints,omitempty"`
+
+	// TagFilter selects which ARM tags are synced to nodes by tag name. Tags owned by the
+	// platform, such as aks-managed-* and creationSource, are excluded by default.
+	// +optional
+	TagFilter *SyncFilter `json:"tagFilter,omitempty"`
+
+	// LabelFilter selects which node labels are synced to ARM tags by label name (node-to-arm
+	// and two-way sync). Kubernetes labels, such as kubernetes.io/* and node.kubernetes.io/*,
+	// are excluded by default.
+	// +optional
+	LabelFilter *SyncFilter `json:"labelFilter,omitempty"`
+}
+
+// SyncFilter is an allow-list and deny-list of tag or label names. A name is synced if it matches
+// none of the exclude patterns and, when there are include patterns, at least one of them.
+type SyncFilter struct {
+	// Include lists the patterns of names to sync. All names are included when empty.
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude lists the patterns of names not to sync, in addition to the default excludes.
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// PatternSyntax is whether patterns are globs, ex: aks-managed-*, or regular expressions in
+	// RE2 syntax. Default is glob.
+	// +kubebuilder:validation:Enum=glob;regex
+	// +optional
+	PatternSyntax string `json:"patternSyntax,omitempty"`
+
+	// SyncPlatformNames turns off the default excludes, so that names owned by the platform
+	// are synced too.
+	// +optional
+	SyncPlatformNames bool `json:"syncPlatformNames,omitempty"`
+}
+
+// TagTransform is a pipeline of rules that change ARM tag names and values into valid label names
+// and values. Explicit mappings are applied first and skip the other rules. Otherwise names go through
+// renames, lowercase, invalidCharReplacement and hashTruncate in that order, and values through the last two.
+// With two-way sync, labels are mapped back to the tags they were created from.
+type TagTransform struct {
+	// NameMappings maps tag names to label names.
+	// +optional
+	NameMappings map[string]string `json:"nameMappings,omitempty"`
+
+	// ValueMappings maps tag values to label values.
+	// +optional
+	ValueMappings map[string]string `json:"valueMappings,omitempty"`
+
+	// Renames are regular expression replacements applied to tag names in order.
+	// +optional
+	Renames []RenameRule `json:"renames,omitempty"`
+
+	// Lowercase converts tag names to lower case.
+	// +optional
+	Lowercase bool `json:"lowercase,omitempty"`
+
+	// InvalidCharReplacement replaces characters that aren't allowed in labels, ex: "-". Characters
+	// are removed if it's empty. If unset, tags with invalid characters are skipped.
+	// +kubebuilder:validation:Pattern=^[-A-Za-z0-9_.]*$
+	// +optional
+	InvalidCharReplacement *string `json:"invalidCharReplacement,omitempty"`
+
+	// HashTruncate shortens names and values over 63 characters and ends them with a hash of the
+	// original, so that shortened names stay unique.
+	// +optional
+	HashTruncate bool `json:"hashTruncate,omitempty"`
+}
+
+// RenameRule replaces matches of a regular expression in tag names.
+type RenameRule struct {
+	// Pattern is a regular expression in RE2 syntax.
+	Pattern string `json:"pattern"`
+
+	// Replacement replaces each match, and can refer to submatches as $1 or ${name}.
+	Replacement string `json:"replacement"`
+}
+
+// TagTaint maps an ARM tag to a node taint, ex: the tag workload=gpu to the taint dedicated=gpu:NoSchedule.
+type TagTaint struct {
+	// TagName is the name of the ARM tag.
+	TagName string `json:"tagName"`
+
+	// TagValue limits the mapping to tags with this value. Any value matches when empty.
+	// +optional
+	TagValue string `json:"tagValue,omitempty"`
+
+	// Key is the taint key.
+	Key string `json:"key"`
+
+	// Value is the taint value. The tag value is used when empty.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Effect is the taint effect.
+	// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+	Effect corev1.TaintEffect `json:"effect"`
+}
+
+// NodeLabelSyncPolicyConditionType is a valid value for NodeLabelSyncPolicyCondition.Type
+type NodeLabelSyncPolicyConditionType string
+
+const (
+	// PolicyValid is true when the spec was accepted by the controller
+	PolicyValid NodeLabelSyncPolicyConditionType = "Valid"
+)
+
+// NodeLabelSyncPolicyCondition describes the state of a policy at a certain point.
+type NodeLabelSyncPolicyCondition struct {
+	Type   NodeLabelSyncPolicyConditionType `json:"type"`
+	Status corev1.ConditionStatus           `json:"status"`
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// SubscriptionStatus is whether the resources in an Azure subscription can be synced.
+type SubscriptionStatus struct {
+	SubscriptionID string `json:"subscriptionID"`
+	// Healthy is false when requests to ARM in the subscription fail, ex: because credentials are invalid
+	// or have no role in the subscription. Nodes in an unhealthy subscription are synced again after a backoff.
+	Healthy bool `json:"healthy"`
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// NodeLabelSyncPolicyStatus defines the observed state of NodeLabelSyncPolicy
+type NodeLabelSyncPolicyStatus struct {
+	// ObservedGeneration is the most recent generation seen by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []NodeLabelSyncPolicyCondition `json:"conditions,omitempty"`
+
+	// Subscriptions is the health of each subscription with nodes.
+	// +optional
+	Subscriptions []SubscriptionStatus `json:"subscriptions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Priority",type="integer",JSONPath=".spec.priority"
+// +kubebuilder:printcolumn:name="Direction",type="string",JSONPath=".spec.syncDirection"
+// +kubebuilder:printcolumn:name="Conflict Policy",type="string",JSONPath=".spec.conflictPolicy"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// NodeLabelSyncPolicy is the Schema for the nodelabelsyncpolicies API
+type NodeLabelSyncPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeLabelSyncPolicySpec   `json:"spec,omitempty"`
+	Status NodeLabelSyncPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NodeLabelSyncPolicyList contains a list of NodeLabelSyncPolicy
+type NodeLabelSyncPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeLabelSyncPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeLabelSyncPolicy{}, &NodeLabelSyncPolicyList{})
+}
@@ -4,34 +4,140 @@
 package azure
 
 import (
+	"sync"
+	"time"
+
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-03-01/compute"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
 )
 
 const userAgent string = "node-label-operator"
 
-func NewVMClient(subID string) (compute.VirtualMachinesClient, error) {
-	a, err := auth.NewAuthorizerFromEnvironment()
-	if err != nil {
-		return compute.VirtualMachinesClient{}, err
+// how long a failure to create the authorizer of a subscription is returned before it's tried again
+const authorizerRetryInterval time.Duration = 30 * time.Second
+
+// ClientFactory returns ARM clients for a subscription. Implementations are safe for concurrent use.
+type ClientFactory interface {
+	VMClient(subscriptionID string) (compute.VirtualMachinesClient, error)
+	ScaleSetClient(subscriptionID string) (compute.VirtualMachineScaleSetsClient, error)
+	ScaleSetVMClient(subscriptionID string) (compute.VirtualMachineScaleSetVMsClient, error)
+}
+
+// Clients is a long-lived ClientFactory that creates the authorizer and clients for each subscription
+// once and reuses them. The bearer token behind an authorizer is refreshed when it's about to expire,
+// so cached clients stay valid. Requests of all clients go through the rate limiter, if there is one.
+// Authorizers are created under a lock of their subscription, so a slow token or Secret read in one
+// subscription doesn't hold up the others.
+type Clients struct {
+	lock          sync.Mutex
+	subscriptions map[string]*subscriptionEntry
+	baseURI       string
+	credentials   Credentials
+	limiter       *RateLimiter
+	now           func() time.Time
+}
+
+// the clients of a subscription, or the last failure to create them
+type subscriptionEntry struct {
+	lock     sync.Mutex
+	clients  *subscriptionClients
+	err      error
+	failedAt time.Time
+}
+
+type subscriptionClients struct {
+	vm       compute.VirtualMachinesClient
+	scaleSet compute.VirtualMachineScaleSetsClient
+	vmssVM   compute.VirtualMachineScaleSetVMsClient
+}
+
+var _ ClientFactory = &Clients{}
+
+// NewClients returns a ClientFactory for the ARM endpoint of the environment that authorizes with the
+// credentials. The zero environment is the public cloud. The limiter is optional.
+func NewClients(environment azure.Environment, credentials Credentials, limiter *RateLimiter) *Clients {
+	return &Clients{
+		subscriptions: map[string]*subscriptionEntry{},
+		baseURI:       resourceManagerURI(environment),
+		credentials:   credentials,
+		limiter:       limiter,
+		now:           time.Now,
 	}
-	client := compute.NewVirtualMachinesClient(subID)
-	client.Authorizer = a
-	if err := client.AddToUserAgent(userAgent); err != nil {
+}
+
+func (c *Clients) VMClient(subscriptionID string) (compute.VirtualMachinesClient, error) {
+	clients, err := c.get(subscriptionID)
+	if err != nil {
 		return compute.VirtualMachinesClient{}, err
 	}
-	return client, nil
+	return clients.vm, nil
 }
 
-func NewScaleSetClient(subID string) (compute.VirtualMachineScaleSetsClient, error) {
-	a, err := auth.NewAuthorizerFromEnvironment()
+func (c *Clients) ScaleSetClient(subscriptionID string) (compute.VirtualMachineScaleSetsClient, error) {
+	clients, err := c.get(subscriptionID)
 	if err != nil {
 		return compute.VirtualMachineScaleSetsClient{}, err
 	}
-	client := compute.NewVirtualMachineScaleSetsClient(subID)
-	client.Authorizer = a
-	if err := client.AddToUserAgent(userAgent); err != nil {
-		return compute.VirtualMachineScaleSetsClient{}, err
+	return clients.scaleSet, nil
+}
+
+func (c *Clients) ScaleSetVMClient(subscriptionID string) (compute.VirtualMachineScaleSetVMsClient, error) {
+	clients, err := c.get(subscriptionID)
+	if err != nil {
+		return compute.VirtualMachineScaleSetVMsClient{}, err
+	}
+	return clients.vmssVM, nil
+}
+
+// return the cached clients for the subscription, creating them on first use. A failure is returned
+// to callers for authorizerRetryInterval, then tried again, so a missing credential can be fixed
+// without restarting.
+func (c *Clients) get(subscriptionID string) (*subscriptionClients, error) {
+	c.lock.Lock()
+	entry, ok := c.subscriptions[subscriptionID]
+	if !ok {
+		entry = &subscriptionEntry{}
+		c.subscriptions[subscriptionID] = entry
+	}
+	c.lock.Unlock()
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+	if entry.clients != nil {
+		return entry.clients, nil
+	}
+	if entry.err != nil && c.now().Sub(entry.failedAt) < authorizerRetryInterval {
+		return nil, entry.err
+	}
+	clients, err := c.newClients(subscriptionID)
+	if err != nil {
+		entry.err, entry.failedAt = err, c.now()
+		return nil, err
+	}
+	entry.clients, entry.err = clients, nil
+	return clients, nil
+}
+
+func (c *Clients) newClients(subscriptionID string) (*subscriptionClients, error) {
+	a, err := c.credentials.Authorizer(subscriptionID)
+	if err != nil {
+		return nil, &CredentialsError{SubscriptionID: subscriptionID, Err: err}
+	}
+
+	clients := &subscriptionClients{
+		vm:       compute.NewVirtualMachinesClientWithBaseURI(c.baseURI, subscriptionID),
+		scaleSet: compute.NewVirtualMachineScaleSetsClientWithBaseURI(c.baseURI, subscriptionID),
+		vmssVM:   compute.NewVirtualMachineScaleSetVMsClientWithBaseURI(c.baseURI, subscriptionID),
+	}
+	for _, client := range []*autorest.Client{&clients.vm.Client, &clients.scaleSet.Client, &clients.vmssVM.Client} {
+		client.Authorizer = a
+		if c.limiter != nil {
+			client.Sender = autorest.CreateSender(c.limiter.SendDecorator(subscriptionID))
+		}
+		if err := client.AddToUserAgent(userAgent); err != nil {
+			return nil, err
+		}
 	}
-	return client, nil
+	return clients, nil
 }
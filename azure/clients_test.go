@@ -0,0 +1,104 @@
+package azure
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+)
+
+type credentialsFunc func(subscriptionID string) (autorest.Authorizer, error)
+
+func (f credentialsFunc) Authorizer(subscriptionID string) (autorest.Authorizer, error) {
+	return f(subscriptionID)
+}
+
+func newTestClients(authorized map[string]int, fail bool) *Clients {
+	return NewClients(azure.Environment{}, credentialsFunc(func(subscriptionID string) (autorest.Authorizer, error) {
+		authorized[subscriptionID]++
+		if fail {
+			return nil, errors.New("no credentials")
+		}
+		return autorest.NullAuthorizer{}, nil
+	}), nil)
+}
+
+func TestClientsReuseAuthorizer(t *testing.T) {
+	authorized := map[string]int{}
+	clients := newTestClients(authorized, false)
+
+	for i := 0; i < 3; i++ {
+		vmClient, err := clients.VMClient("sub1")
+		assert.NoError(t, err)
+		assert.Equal(t, "sub1", vmClient.SubscriptionID)
+		vmssClient, err := clients.ScaleSetClient("sub1")
+		assert.NoError(t, err)
+		assert.NotNil(t, vmssClient.Authorizer)
+		_, err = clients.ScaleSetVMClient("sub2")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]int{"sub1": 1, "sub2": 1}, authorized)
+}
+
+func TestClientsUseEnvironment(t *testing.T) {
+	clients := NewClients(azure.USGovernmentCloud, credentialsFunc(func(string) (autorest.Authorizer, error) {
+		return autorest.NullAuthorizer{}, nil
+	}), nil)
+
+	vmClient, err := clients.VMClient("sub1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://management.usgovcloudapi.net", vmClient.BaseURI)
+	vmssVMClient, err := clients.ScaleSetVMClient("sub1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://management.usgovcloudapi.net", vmssVMClient.BaseURI)
+
+	vmClient, err = newTestClients(map[string]int{}, false).VMClient("sub1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://management.azure.com", vmClient.BaseURI)
+}
+
+func TestClientsRetryFailures(t *testing.T) {
+	authorized := map[string]int{}
+	clients := newTestClients(authorized, true)
+	now := time.Now()
+	clients.now = func() time.Time { return now }
+
+	_, err := clients.VMClient("sub1")
+	assert.Error(t, err)
+	_, err = clients.VMClient("sub1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, authorized["sub1"])
+
+	now = now.Add(authorizerRetryInterval)
+	_, err = clients.VMClient("sub1")
+	assert.Error(t, err)
+	assert.Equal(t, 2, authorized["sub1"])
+}
+
+func TestClientsLockPerSubscription(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	clients := NewClients(azure.Environment{}, credentialsFunc(func(subscriptionID string) (autorest.Authorizer, error) {
+		if subscriptionID == "slow" {
+			close(blocked)
+			<-release
+		}
+		return autorest.NullAuthorizer{}, nil
+	}), nil)
+
+	done := make(chan error)
+	go func() {
+		_, err := clients.VMClient("slow")
+		done <- err
+	}()
+	<-blocked
+
+	// other subscriptions don't wait for the slow authorizer
+	_, err := clients.VMClient("sub1")
+	assert.NoError(t, err)
+	close(release)
+	assert.NoError(t, <-done)
+}
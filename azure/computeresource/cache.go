@@ -0,0 +1,152 @@
+package computeresource
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache holds compute resources read from ARM for a TTL, so that the nodes on a VMSS share one GET
+// per period instead of each reading the scale set. It's safe for concurrent use, and concurrent reads
+// of a missing resource wait for a single GET. Resources are invalidated when the operator writes to
+// them. A nil Cache reads through to ARM every time.
+type Cache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	fetch   func(ctx context.Context) (interface{}, error)
+	ready   chan struct{} // closed once value and err are set
+	value   interface{}
+	err     error
+	fetched time.Time
+	used    time.Time
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: map[string]*cacheEntry{}, now: time.Now}
+}
+
+// CacheKey identifies a compute resource, ignoring case like ARM does
+func CacheKey(subscriptionID, resourceGroup, resourceType string, names ...string) string {
+	parts := append([]string{subscriptionID, resourceGroup, resourceType}, names...)
+	return strings.ToLower(strings.Join(parts, "/"))
+}
+
+// Get returns the cached value for key if it's younger than the TTL, otherwise it calls fetch and
+// caches the result. Errors aren't cached. Callers must not modify the returned value.
+func (c *Cache) Get(ctx context.Context, key string, fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		entry.used = c.now()
+		select {
+		case <-entry.ready:
+			if c.now().Sub(entry.fetched) < c.ttl {
+				c.lock.Unlock()
+				return entry.value, nil
+			}
+			ok = false // expired
+		default:
+			// another reconcile is already reading it
+		}
+	}
+	if !ok {
+		entry = &cacheEntry{fetch: fetch, ready: make(chan struct{}), used: c.now()}
+		c.entries[key] = entry
+		c.lock.Unlock()
+		c.load(ctx, key, entry)
+	} else {
+		c.lock.Unlock()
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.value, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate removes key from the cache, so the next Get reads it from ARM
+func (c *Cache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+// Refresh reads every resource used since the last refresh again, so they stay fresh without waiting
+// for a reconcile to miss, and evicts the rest
+func (c *Cache) Refresh(ctx context.Context, since time.Time) {
+	c.lock.Lock()
+	stale := map[string]*cacheEntry{}
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+		default:
+			continue // being read
+		}
+		if entry.used.Before(since) {
+			delete(c.entries, key)
+			continue
+		}
+		refreshed := &cacheEntry{fetch: entry.fetch, ready: make(chan struct{}), used: entry.used}
+		c.entries[key] = refreshed
+		stale[key] = refreshed
+	}
+	c.lock.Unlock()
+
+	for key, entry := range stale {
+		c.load(ctx, key, entry)
+	}
+}
+
+// RefreshEvery returns a manager runnable that refreshes the cache every period until stopped
+func (c *Cache) RefreshEvery(period time.Duration) func(stop <-chan struct{}) error {
+	return func(stop <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop
+			cancel()
+		}()
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		last := c.now()
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-ticker.C:
+				now := c.now()
+				c.Refresh(ctx, last)
+				last = now
+			}
+		}
+	}
+}
+
+// fetch the value for an entry, removing the entry on error so the next Get tries again
+func (c *Cache) load(ctx context.Context, key string, entry *cacheEntry) {
+	value, err := entry.fetch(ctx)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry.value, entry.err, entry.fetched = value, err, c.now()
+	if err != nil && c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+	close(entry.ready)
+}
@@ -0,0 +1,141 @@
+package computeresource
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache(ttl time.Duration) (*Cache, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cache := NewCache(ttl)
+	cache.now = clock.Now
+	return cache, clock
+}
+
+// returns a fetch function that counts its calls and returns the count
+func countingFetch(count *int, lock *sync.Mutex) func(context.Context) (interface{}, error) {
+	return func(context.Context) (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		*count++
+		return *count, nil
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	ctx := context.Background()
+	var lock sync.Mutex
+	count := 0
+	key := CacheKey("sub", "RG", VMSS, "vmss1")
+
+	for i := 0; i < 3; i++ {
+		val, err := cache.Get(ctx, key, countingFetch(&count, &lock))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, val)
+	}
+
+	// resource IDs aren't case sensitive
+	val, err := cache.Get(ctx, CacheKey("SUB", "rg", VMSS, "VMSS1"), countingFetch(&count, &lock))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, val)
+
+	clock.now = clock.now.Add(time.Minute)
+	val, err = cache.Get(ctx, key, countingFetch(&count, &lock))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, val)
+
+	cache.Invalidate(key)
+	val, err = cache.Get(ctx, key, countingFetch(&count, &lock))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, val)
+}
+
+func TestCacheConcurrentGet(t *testing.T) {
+	cache, _ := newTestCache(time.Minute)
+	ctx := context.Background()
+	var lock sync.Mutex
+	count := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := cache.Get(ctx, "key", countingFetch(&count, &lock))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, val)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, count)
+}
+
+func TestCacheErrorsNotCached(t *testing.T) {
+	cache, _ := newTestCache(time.Minute)
+	ctx := context.Background()
+	calls := 0
+	failing := func(context.Context) (interface{}, error) {
+		calls++
+		return nil, errors.New("throttled")
+	}
+
+	_, err := cache.Get(ctx, "key", failing)
+	assert.Error(t, err)
+	_, err = cache.Get(ctx, "key", failing)
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCacheRefresh(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	ctx := context.Background()
+	var lock sync.Mutex
+	used, unused := 0, 0
+
+	_, err := cache.Get(ctx, "used", countingFetch(&used, &lock))
+	assert.NoError(t, err)
+	_, err = cache.Get(ctx, "unused", countingFetch(&unused, &lock))
+	assert.NoError(t, err)
+
+	lastRefresh := clock.now.Add(time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+	_, err = cache.Get(ctx, "used", countingFetch(&used, &lock))
+	assert.NoError(t, err)
+	cache.Refresh(ctx, lastRefresh)
+	assert.Equal(t, 2, used)
+	assert.Equal(t, 1, unused)
+
+	// refreshed resources are served from the cache, evicted ones are read again
+	val, err := cache.Get(ctx, "used", countingFetch(&used, &lock))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, val)
+	val, err = cache.Get(ctx, "unused", countingFetch(&unused, &lock))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, val)
+}
+
+func TestNilCache(t *testing.T) {
+	var cache *Cache
+	var lock sync.Mutex
+	count := 0
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get(context.Background(), "key", countingFetch(&count, &lock))
+		assert.NoError(t, err)
+	}
+	cache.Invalidate("key")
+	assert.Equal(t, 2, count)
+}
@@ -16,5 +16,6 @@ type ComputeResource interface {
 	ID() string
 	Tags() map[string]*string
 	SetTag(name string, value *string)
+	DeleteTag(name string)
 	Update(ctx context.Context) error
 }
@@ -31,3 +31,7 @@ func (c FakeComputeResource) Tags() map[string]*string {
 func (c FakeComputeResource) SetTag(name string, value *string) {
 	c.tags[name] = value
 }
+
+func (c FakeComputeResource) DeleteTag(name string) {
+	delete(c.tags, name)
+}
@@ -0,0 +1,67 @@
+package computeresource
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var writesWithoutETag = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "node_label_operator_arm_writes_without_etag_total",
+	Help: "Tag writes sent without If-Match because ARM returned no ETag, checked by comparing tags before writing instead",
+}, []string{"resource_type"})
+
+func init() {
+	metrics.Registry.MustRegister(writesWithoutETag)
+}
+
+// etag returns the ETag header of a response read from ARM, or "" if there is none
+func etag(response autorest.Response) string {
+	if response.Response == nil {
+		return ""
+	}
+	return response.Header.Get("ETag")
+}
+
+// withIfMatch returns a copy of the client that sends If-Match with the given ETag, so that a write fails
+// with 412 Precondition Failed if the resource changed since it was read. The client is unchanged if
+// there is no ETag.
+func withIfMatch(client autorest.Client, etag string) autorest.Client {
+	if etag == "" {
+		return client
+	}
+	inspector := client.RequestInspector
+	client.RequestInspector = func(p autorest.Preparer) autorest.Preparer {
+		p = autorest.WithHeader(http.CanonicalHeaderKey("If-Match"), etag)(p)
+		if inspector != nil {
+			p = inspector(p)
+		}
+		return p
+	}
+	return client
+}
+
+// tagsChanged is returned when the tags on ARM no longer match the tags that were read. It's a 412, the same
+// as a failed If-Match, so callers read the resource again and retry.
+func tagsChanged(name string) error {
+	return autorest.DetailedError{
+		StatusCode: http.StatusPreconditionFailed,
+		Message:    fmt.Sprintf("tags of %s changed since they were read", name),
+	}
+}
+
+func tagsEqual(a, b map[string]*string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, val := range a {
+		other, ok := b[name]
+		if !ok || (val == nil) != (other == nil) || (val != nil && *val != *other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package computeresource
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithIfMatch(t *testing.T) {
+	var etagTest = []struct {
+		name     string
+		etag     string
+		expected string
+	}{
+		{"etag", `W/"1"`, `W/"1"`},
+		{"no etag", "", ""},
+	}
+
+	for _, tt := range etagTest {
+		t.Run(tt.name, func(t *testing.T) {
+			client := withIfMatch(autorest.NewClientWithUserAgent("test"), tt.etag)
+			req, err := autorest.Prepare(&http.Request{Header: http.Header{}}, client.WithInspection())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, req.Header.Get("If-Match"))
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	assert.Equal(t, "", etag(autorest.Response{}))
+	response := autorest.Response{Response: &http.Response{Header: http.Header{"Etag": []string{`"2"`}}}}
+	assert.Equal(t, `"2"`, etag(response))
+}
+
+func TestTagsEqual(t *testing.T) {
+	a, b := "a", "b"
+	var tagsTest = []struct {
+		name     string
+		tags     map[string]*string
+		other    map[string]*string
+		expected bool
+	}{
+		{"same", map[string]*string{"x": &a}, map[string]*string{"x": &a}, true},
+		{"both empty", nil, map[string]*string{}, true},
+		{"value changed", map[string]*string{"x": &a}, map[string]*string{"x": &b}, false},
+		{"nil value", map[string]*string{"x": nil}, map[string]*string{"x": &a}, false},
+		{"tag added", map[string]*string{"x": &a}, map[string]*string{"x": &a, "y": &b}, false},
+		{"tag renamed", map[string]*string{"x": &a}, map[string]*string{"y": &a}, false},
+	}
+
+	for _, tt := range tagsTest {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tagsEqual(tt.tags, tt.other))
+			assert.Equal(t, tt.expected, tagsEqual(tt.other, tt.tags))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package computeresource
+
+import (
+	"context"
+)
+
+// ScaleSetInstance is a VMSS combined with one of its instances. Tags are read from both, with
+// the instance's tags taking precedence over the scale set's or the other way around. Changes are
+// written to the scale set.
+type ScaleSetInstance struct {
+	scaleSet           ComputeResource
+	instance           ComputeResource
+	instancePrecedence bool
+}
+
+func NewScaleSetInstance(scaleSet, instance ComputeResource, instancePrecedence bool) *ScaleSetInstance {
+	return &ScaleSetInstance{scaleSet: scaleSet, instance: instance, instancePrecedence: instancePrecedence}
+}
+
+func (m ScaleSetInstance) Update(ctx context.Context) error {
+	return m.scaleSet.Update(ctx)
+}
+
+func (m ScaleSetInstance) Name() string {
+	return m.scaleSet.Name()
+}
+
+func (m ScaleSetInstance) ID() string {
+	return m.scaleSet.ID()
+}
+
+func (m ScaleSetInstance) Tags() map[string]*string {
+	first, second := m.instance, m.scaleSet
+	if m.instancePrecedence {
+		first, second = m.scaleSet, m.instance
+	}
+	tags := map[string]*string{}
+	for name, value := range first.Tags() {
+		tags[name] = value
+	}
+	for name, value := range second.Tags() {
+		tags[name] = value // overrides tags from first
+	}
+	return tags
+}
+
+func (m ScaleSetInstance) SetTag(name string, value *string) {
+	m.scaleSet.SetTag(name, value)
+}
+
+func (m ScaleSetInstance) DeleteTag(name string) {
+	m.scaleSet.DeleteTag(name)
+}
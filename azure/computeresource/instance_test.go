@@ -0,0 +1,24 @@
+package computeresource
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleSetInstanceTags(t *testing.T) {
+	scaleSet := NewFakeComputeResource(map[string]*string{"env": to.StringPtr("prod"), "team": to.StringPtr("infra")})
+	instance := NewFakeComputeResource(map[string]*string{"env": to.StringPtr("canary"), "slot": to.StringPtr("1")})
+
+	merged := NewScaleSetInstance(scaleSet, instance, true)
+	assert.Equal(t, map[string]*string{"env": to.StringPtr("canary"), "team": to.StringPtr("infra"), "slot": to.StringPtr("1")}, merged.Tags())
+
+	merged = NewScaleSetInstance(scaleSet, instance, false)
+	assert.Equal(t, map[string]*string{"env": to.StringPtr("prod"), "team": to.StringPtr("infra"), "slot": to.StringPtr("1")}, merged.Tags())
+
+	// changes are written to the scale set
+	merged.SetTag("new", to.StringPtr("tag"))
+	assert.Equal(t, to.StringPtr("tag"), scaleSet.Tags()["new"])
+	assert.NotContains(t, instance.Tags(), "new")
+}
@@ -0,0 +1,117 @@
+package computeresource
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-03-01/compute"
+)
+
+// keys of the Azure platform metadata of a compute resource
+const (
+	PlatformSize                    string = "size"
+	PlatformPriority                string = "priority"
+	PlatformEvictionPolicy          string = "eviction-policy"
+	PlatformProximityPlacementGroup string = "proximity-placement-group"
+	PlatformAvailabilitySet         string = "availability-set"
+	PlatformImage                   string = "image"
+	PlatformOSDiskType              string = "os-disk-type"
+)
+
+// PlatformKeys are all the keys of platform metadata, whether or not a resource has them
+var PlatformKeys = []string{PlatformSize, PlatformPriority, PlatformEvictionPolicy, PlatformProximityPlacementGroup,
+	PlatformAvailabilitySet, PlatformImage, PlatformOSDiskType}
+
+// PlatformResource is a compute resource with Azure platform metadata, such as its size
+// or priority, that can be applied to nodes as labels
+type PlatformResource interface {
+	PlatformMetadata() map[string]string
+}
+
+func (m VirtualMachine) PlatformMetadata() map[string]string {
+	return vmPlatformMetadata(m.vm)
+}
+
+func (m VirtualMachineScaleSet) PlatformMetadata() map[string]string {
+	return vmssPlatformMetadata(m.vmss)
+}
+
+func vmPlatformMetadata(vm *compute.VirtualMachine) map[string]string {
+	metadata := map[string]string{}
+	props := vm.VirtualMachineProperties
+	if props == nil {
+		return metadata
+	}
+	if props.HardwareProfile != nil {
+		setMetadata(metadata, PlatformSize, string(props.HardwareProfile.VMSize))
+	}
+	setMetadata(metadata, PlatformPriority, string(props.Priority))
+	setMetadata(metadata, PlatformEvictionPolicy, string(props.EvictionPolicy))
+	if props.ProximityPlacementGroup != nil && props.ProximityPlacementGroup.ID != nil {
+		setMetadata(metadata, PlatformProximityPlacementGroup, resourceName(*props.ProximityPlacementGroup.ID))
+	}
+	if props.AvailabilitySet != nil && props.AvailabilitySet.ID != nil {
+		setMetadata(metadata, PlatformAvailabilitySet, resourceName(*props.AvailabilitySet.ID))
+	}
+	if props.StorageProfile != nil {
+		setMetadata(metadata, PlatformImage, imageName(props.StorageProfile.ImageReference))
+		if props.StorageProfile.OsDisk != nil && props.StorageProfile.OsDisk.ManagedDisk != nil {
+			setMetadata(metadata, PlatformOSDiskType, string(props.StorageProfile.OsDisk.ManagedDisk.StorageAccountType))
+		}
+	}
+	return metadata
+}
+
+func vmssPlatformMetadata(vmss *compute.VirtualMachineScaleSet) map[string]string {
+	metadata := map[string]string{}
+	if vmss.Sku != nil && vmss.Sku.Name != nil {
+		setMetadata(metadata, PlatformSize, *vmss.Sku.Name)
+	}
+	props := vmss.VirtualMachineScaleSetProperties
+	if props == nil {
+		return metadata
+	}
+	if props.ProximityPlacementGroup != nil && props.ProximityPlacementGroup.ID != nil {
+		setMetadata(metadata, PlatformProximityPlacementGroup, resourceName(*props.ProximityPlacementGroup.ID))
+	}
+	profile := props.VirtualMachineProfile
+	if profile == nil {
+		return metadata
+	}
+	setMetadata(metadata, PlatformPriority, string(profile.Priority))
+	setMetadata(metadata, PlatformEvictionPolicy, string(profile.EvictionPolicy))
+	if profile.StorageProfile != nil {
+		setMetadata(metadata, PlatformImage, imageName(profile.StorageProfile.ImageReference))
+		if profile.StorageProfile.OsDisk != nil && profile.StorageProfile.OsDisk.ManagedDisk != nil {
+			setMetadata(metadata, PlatformOSDiskType, string(profile.StorageProfile.OsDisk.ManagedDisk.StorageAccountType))
+		}
+	}
+	return metadata
+}
+
+func setMetadata(metadata map[string]string, key, value string) {
+	if value != "" {
+		metadata[key] = value
+	}
+}
+
+// marketplace images are named publisher_offer_sku_version, and custom images by their resource name
+func imageName(image *compute.ImageReference) string {
+	if image == nil {
+		return ""
+	}
+	if image.ID != nil && *image.ID != "" {
+		return resourceName(*image.ID)
+	}
+	var parts []string
+	for _, part := range []*string{image.Publisher, image.Offer, image.Sku, image.Version} {
+		if part != nil && *part != "" {
+			parts = append(parts, *part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// last segment of a resource ID
+func resourceName(id string) string {
+	return id[strings.LastIndex(id, "/")+1:]
+}
@@ -0,0 +1,60 @@
+package computeresource
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-03-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMPlatformMetadata(t *testing.T) {
+	vm := &compute.VirtualMachine{
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			HardwareProfile: &compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesStandardD2sV3},
+			Priority:        compute.Low,
+			EvictionPolicy:  compute.Deallocate,
+			AvailabilitySet: &compute.SubResource{ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/availabilitySets/AS1")},
+			StorageProfile: &compute.StorageProfile{
+				ImageReference: &compute.ImageReference{
+					Publisher: to.StringPtr("Canonical"),
+					Offer:     to.StringPtr("UbuntuServer"),
+					Sku:       to.StringPtr("16.04-LTS"),
+					Version:   to.StringPtr("latest"),
+				},
+				OsDisk: &compute.OSDisk{ManagedDisk: &compute.ManagedDiskParameters{StorageAccountType: compute.StorageAccountTypesPremiumLRS}},
+			},
+		},
+	}
+	assert.Equal(t, map[string]string{
+		PlatformSize:            "Standard_D2s_v3",
+		PlatformPriority:        "Low",
+		PlatformEvictionPolicy:  "Deallocate",
+		PlatformAvailabilitySet: "AS1",
+		PlatformImage:           "Canonical_UbuntuServer_16.04-LTS_latest",
+		PlatformOSDiskType:      "Premium_LRS",
+	}, vmPlatformMetadata(vm))
+}
+
+func TestVMSSPlatformMetadata(t *testing.T) {
+	vmss := &compute.VirtualMachineScaleSet{
+		Sku: &compute.Sku{Name: to.StringPtr("Standard_DS2_v2")},
+		VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+			ProximityPlacementGroup: &compute.SubResource{ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/proximityPlacementGroups/ppg1")},
+			VirtualMachineProfile: &compute.VirtualMachineScaleSetVMProfile{
+				Priority: compute.Regular,
+				StorageProfile: &compute.VirtualMachineScaleSetStorageProfile{
+					ImageReference: &compute.ImageReference{ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/aks-image")},
+				},
+			},
+		},
+	}
+	assert.Equal(t, map[string]string{
+		PlatformSize:                    "Standard_DS2_v2",
+		PlatformPriority:                "Regular",
+		PlatformProximityPlacementGroup: "ppg1",
+		PlatformImage:                   "aks-image",
+	}, vmssPlatformMetadata(vmss))
+
+	assert.Equal(t, map[string]string{}, vmssPlatformMetadata(&compute.VirtualMachineScaleSet{}))
+}
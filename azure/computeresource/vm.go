@@ -12,29 +12,48 @@ type VirtualMachine struct {
 	name   string
 	client *compute.VirtualMachinesClient
 	vm     *compute.VirtualMachine
+	cache  *Cache
+	key    string
+	etag   string // sent as If-Match when writing tags
+	read   map[string]*string
 }
 
-func NewVM(ctx context.Context, subscriptionID, resourceGroup, resourceName string) (*VirtualMachine, error) {
-	client, err := azure.NewVMClient(subscriptionID)
+func NewVM(ctx context.Context, clients azure.ClientFactory, cache *Cache, subscriptionID, resourceGroup, resourceName string) (*VirtualMachine, error) {
+	client, err := clients.VMClient(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
-	vm, err := client.Get(ctx, resourceGroup, resourceName, compute.InstanceView)
+	key := CacheKey(subscriptionID, resourceGroup, VM, resourceName)
+	cached, err := cache.Get(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return client.Get(ctx, resourceGroup, resourceName, compute.InstanceView)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	vm = VMUserAssignedIdentity(vm)
+	vm := cached.(compute.VirtualMachine)
+	read := vm.Tags
+	vm.Tags = copyTags(vm.Tags) // so the cached VM stays unchanged
 
-	return &VirtualMachine{group: resourceGroup, name: resourceName, client: &client, vm: &vm}, nil
+	return &VirtualMachine{group: resourceGroup, name: resourceName, client: &client, vm: &vm, cache: cache, key: key,
+		etag: etag(vm.Response), read: read}, nil
 }
 
 func NewVMInitialized(ctx context.Context, resourceGroup string, c *compute.VirtualMachinesClient, v *compute.VirtualMachine) *VirtualMachine {
 	return &VirtualMachine{group: resourceGroup, name: *v.Name, client: c, vm: v}
 }
 
+// Update writes only the tags of the VM, so other properties are left alone. The write fails with
+// 412 Precondition Failed if the VM's ETag changed since it was read.
 func (m VirtualMachine) Update(ctx context.Context) error {
-	f, err := m.client.CreateOrUpdate(ctx, m.group, m.name, *m.vm)
+	defer m.cache.Invalidate(m.key)
+	client := *m.client
+	etag, err := m.ifMatch(ctx)
+	if err != nil {
+		return err
+	}
+	client.Client = withIfMatch(client.Client, etag)
+	f, err := client.Update(ctx, m.group, m.name, compute.VirtualMachineUpdate{Tags: m.vm.Tags})
 	if err != nil {
 		return err
 	}
@@ -68,12 +87,35 @@ func (m VirtualMachine) SetTag(name string, value *string) {
 	m.vm.Tags[name] = value
 }
 
-func VMUserAssignedIdentity(vm compute.VirtualMachine) compute.VirtualMachine {
-	if vm.Identity != nil {
-		vm.Identity.Type = compute.ResourceIdentityTypeUserAssigned
-		for id := range vm.Identity.UserAssignedIdentities {
-			vm.Identity.UserAssignedIdentities[id] = &compute.VirtualMachineIdentityUserAssignedIdentitiesValue{}
-		}
+func (m VirtualMachine) DeleteTag(name string) {
+	delete(m.vm.Tags, name)
+}
+
+// copy tags so that changes don't affect the cached resource. Resources without tags get an empty map.
+func copyTags(tags map[string]*string) map[string]*string {
+	copied := map[string]*string{}
+	for name, val := range tags {
+		copied[name] = val
+	}
+	return copied
+}
+
+// return the ETag to send as If-Match. The compute API doesn't always return an ETag, so without one the
+// VM is read again, and the write fails with 412 if its tags changed since they were read.
+func (m VirtualMachine) ifMatch(ctx context.Context) (string, error) {
+	if m.etag != "" {
+		return m.etag, nil
+	}
+	current, err := m.client.Get(ctx, m.group, m.name, "")
+	if err != nil {
+		return "", err
+	}
+	if !tagsEqual(current.Tags, m.read) {
+		return "", tagsChanged(m.name)
+	}
+	if currentETag := etag(current.Response); currentETag != "" {
+		return currentETag, nil
 	}
-	return vm
+	writesWithoutETag.WithLabelValues(VM).Inc()
+	return "", nil
 }
@@ -12,29 +12,48 @@ type VirtualMachineScaleSet struct {
 	name   string
 	client *compute.VirtualMachineScaleSetsClient
 	vmss   *compute.VirtualMachineScaleSet
+	cache  *Cache
+	key    string
+	etag   string // sent as If-Match when writing tags
+	read   map[string]*string
 }
 
-func NewVMSS(ctx context.Context, subscriptionID, resourceGroup, resourceName string) (*VirtualMachineScaleSet, error) {
-	client, err := azure.NewScaleSetClient(subscriptionID)
+func NewVMSS(ctx context.Context, clients azure.ClientFactory, cache *Cache, subscriptionID, resourceGroup, resourceName string) (*VirtualMachineScaleSet, error) {
+	client, err := clients.ScaleSetClient(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
-	vmss, err := client.Get(ctx, resourceGroup, resourceName)
+	key := CacheKey(subscriptionID, resourceGroup, VMSS, resourceName)
+	cached, err := cache.Get(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return client.Get(ctx, resourceGroup, resourceName)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	vmss = VMSSUserAssignedIdentity(vmss)
+	vmss := cached.(compute.VirtualMachineScaleSet)
+	read := vmss.Tags
+	vmss.Tags = copyTags(vmss.Tags) // so the cached scale set stays unchanged
 
-	return &VirtualMachineScaleSet{group: resourceGroup, name: resourceName, client: &client, vmss: &vmss}, nil
+	return &VirtualMachineScaleSet{group: resourceGroup, name: resourceName, client: &client, vmss: &vmss, cache: cache, key: key,
+		etag: etag(vmss.Response), read: read}, nil
 }
 
 func NewVMSSInitialized(ctx context.Context, resourceGroup string, c *compute.VirtualMachineScaleSetsClient, v *compute.VirtualMachineScaleSet) *VirtualMachineScaleSet {
 	return &VirtualMachineScaleSet{group: resourceGroup, name: *v.Name, client: c, vmss: v}
 }
 
+// Update writes only the tags of the scale set, so other properties and the scale set model are left alone.
+// The write fails with 412 Precondition Failed if the scale set's ETag changed since it was read.
 func (m VirtualMachineScaleSet) Update(ctx context.Context) error {
-	f, err := m.client.CreateOrUpdate(ctx, m.group, m.name, *m.vmss)
+	defer m.cache.Invalidate(m.key)
+	client := *m.client
+	etag, err := m.ifMatch(ctx)
+	if err != nil {
+		return err
+	}
+	client.Client = withIfMatch(client.Client, etag)
+	f, err := client.Update(ctx, m.group, m.name, compute.VirtualMachineScaleSetUpdate{Tags: m.vmss.Tags})
 	if err != nil {
 		return err
 	}
@@ -68,12 +87,26 @@ func (m VirtualMachineScaleSet) SetTag(name string, value *string) {
 	m.vmss.Tags[name] = value
 }
 
-func VMSSUserAssignedIdentity(vmss compute.VirtualMachineScaleSet) compute.VirtualMachineScaleSet {
-	if vmss.Identity != nil { // is this an error otherwise?
-		vmss.Identity.Type = compute.ResourceIdentityTypeUserAssigned
-		for id := range vmss.Identity.UserAssignedIdentities {
-			vmss.Identity.UserAssignedIdentities[id] = &compute.VirtualMachineScaleSetIdentityUserAssignedIdentitiesValue{}
-		}
+func (m VirtualMachineScaleSet) DeleteTag(name string) {
+	delete(m.vmss.Tags, name)
+}
+
+// return the ETag to send as If-Match. The compute API doesn't always return an ETag, so without one the
+// scale set is read again, and the write fails with 412 if its tags changed since they were read.
+func (m VirtualMachineScaleSet) ifMatch(ctx context.Context) (string, error) {
+	if m.etag != "" {
+		return m.etag, nil
+	}
+	current, err := m.client.Get(ctx, m.group, m.name)
+	if err != nil {
+		return "", err
+	}
+	if !tagsEqual(current.Tags, m.read) {
+		return "", tagsChanged(m.name)
+	}
+	if currentETag := etag(current.Response); currentETag != "" {
+		return currentETag, nil
 	}
-	return vmss
+	writesWithoutETag.WithLabelValues(VMSS).Inc()
+	return "", nil
 }
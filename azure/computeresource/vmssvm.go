@@ -0,0 +1,64 @@
+package computeresource
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-03-01/compute"
+	"github.com/Azure/node-label-operator/azure"
+)
+
+var ErrInstanceReadOnly = errors.New("tags of VMSS instances are read only")
+
+// VirtualMachineScaleSetVM is a single instance of a VMSS, which can have its own tags
+type VirtualMachineScaleSetVM struct {
+	group      string
+	vmssName   string
+	instanceID string
+	client     *compute.VirtualMachineScaleSetVMsClient
+	vm         *compute.VirtualMachineScaleSetVM
+}
+
+func NewVMSSVM(ctx context.Context, clients azure.ClientFactory, cache *Cache, subscriptionID, resourceGroup, vmssName, instanceID string) (*VirtualMachineScaleSetVM, error) {
+	client, err := clients.ScaleSetVMClient(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	key := CacheKey(subscriptionID, resourceGroup, VMSS, vmssName, VM, instanceID)
+	cached, err := cache.Get(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return client.Get(ctx, resourceGroup, vmssName, instanceID, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	vm := cached.(compute.VirtualMachineScaleSetVM)
+	vm.Tags = copyTags(vm.Tags)
+
+	return &VirtualMachineScaleSetVM{group: resourceGroup, vmssName: vmssName, instanceID: instanceID, client: &client, vm: &vm}, nil
+}
+
+// Update returns an error, since VMSS instances can only be written with a PUT of the whole instance, which
+// could revert other changes to it. Changes are written to the scale set instead, see ScaleSetInstance.
+func (m VirtualMachineScaleSetVM) Update(ctx context.Context) error {
+	return ErrInstanceReadOnly
+}
+
+func (m VirtualMachineScaleSetVM) Name() string {
+	return *m.vm.Name
+}
+
+func (m VirtualMachineScaleSetVM) ID() string {
+	return *m.vm.ID
+}
+
+func (m VirtualMachineScaleSetVM) Tags() map[string]*string {
+	return m.vm.Tags
+}
+
+func (m VirtualMachineScaleSetVM) SetTag(name string, value *string) {
+	m.vm.Tags[name] = value
+}
+
+func (m VirtualMachineScaleSetVM) DeleteTag(name string) {
+	delete(m.vm.Tags, name)
+}
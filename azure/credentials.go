@@ -0,0 +1,412 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package azure
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"golang.org/x/crypto/pkcs12"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type AuthMode string
+
+const (
+	// credentials from AZURE_* environment variables, ex: set by aad-pod-identity
+	EnvironmentAuth AuthMode = "environment"
+	// client secret from a Kubernetes Secret
+	ClientSecretAuth AuthMode = "client-secret"
+	// PKCS #12 client certificate from a Kubernetes Secret
+	ClientCertificateAuth AuthMode = "client-certificate"
+	// managed identity of the VM the operator runs on, optionally a user-assigned identity chosen by client ID
+	ManagedIdentityAuth AuthMode = "managed-identity"
+	// federated token projected into the pod by Azure AD workload identity
+	WorkloadIdentityAuth AuthMode = "workload-identity"
+	// the cloud provider config file on AKS and aks-engine nodes
+	AzureJSONAuth AuthMode = "azure-json"
+)
+
+const (
+	DefaultAzureJSONPath string = "/etc/kubernetes/azure.json"
+	// keys of the Kubernetes Secret with client credentials
+	ClientSecretKey              string = "clientSecret"
+	ClientCertificateKey         string = "clientCertificate"
+	ClientCertificatePasswordKey string = "clientCertificatePassword"
+
+	clientAssertionType string        = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	secretReadTimeout   time.Duration = 30 * time.Second
+)
+
+// Credentials creates the authorizer for ARM requests in a subscription
+type Credentials interface {
+	Authorizer(subscriptionID string) (autorest.Authorizer, error)
+}
+
+// CredentialConfig is a source of credentials chosen by Mode. Tokens are refreshed from the source as they
+// expire, so a rotated Kubernetes Secret or projected token is picked up without restarting.
+type CredentialConfig struct {
+	Mode     AuthMode
+	TenantID string
+	// ClientID of the service principal, or of the user-assigned managed identity
+	ClientID string
+	// Secret holds the client secret or certificate, see ClientSecretKey and ClientCertificateKey
+	Secret types.NamespacedName
+	// Secrets reads the Secret. It should read from the API server, so that rotations are seen right away.
+	Secrets client.Reader
+	// TokenFile is the projected service account token for workload identity
+	TokenFile string
+	// AzureJSONPath is the path of the cloud provider config file
+	AzureJSONPath string
+	// Environment has the Azure AD and ARM endpoints of the cloud. The zero value is the public cloud.
+	Environment azure.Environment
+}
+
+var _ Credentials = CredentialConfig{}
+
+// fill in unset settings from the environment variables set by the workload identity webhook, and defaults
+func (c CredentialConfig) withDefaults() CredentialConfig {
+	if c.Mode == "" {
+		c.Mode = EnvironmentAuth
+	}
+	if c.Mode == WorkloadIdentityAuth {
+		if c.TenantID == "" {
+			c.TenantID = os.Getenv("AZURE_TENANT_ID")
+		}
+		if c.ClientID == "" {
+			c.ClientID = os.Getenv("AZURE_CLIENT_ID")
+		}
+		if c.TokenFile == "" {
+			c.TokenFile = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+		}
+	}
+	if c.AzureJSONPath == "" {
+		c.AzureJSONPath = DefaultAzureJSONPath
+	}
+	c.Environment = environmentOrDefault(c.Environment)
+	return c
+}
+
+// Validate returns an error if settings needed by the auth mode are missing
+func (c CredentialConfig) Validate() error {
+	c = c.withDefaults()
+	switch c.Mode {
+	case EnvironmentAuth, ManagedIdentityAuth, AzureJSONAuth:
+		return nil
+	case ClientSecretAuth, ClientCertificateAuth:
+		if c.TenantID == "" || c.ClientID == "" {
+			return fmt.Errorf("tenant ID and client ID must be set for %s auth", c.Mode)
+		}
+		if c.Secret.Name == "" || c.Secret.Namespace == "" {
+			return fmt.Errorf("secret namespace and name must be set for %s auth", c.Mode)
+		}
+		if c.Secrets == nil {
+			return fmt.Errorf("secret reader must be set for %s auth", c.Mode)
+		}
+		return nil
+	case WorkloadIdentityAuth:
+		if c.TenantID == "" || c.ClientID == "" || c.TokenFile == "" {
+			return fmt.Errorf("tenant ID, client ID and token file must be set for %s auth", c.Mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid auth mode %q, must be one of %s, %s, %s, %s, %s or %s", c.Mode, EnvironmentAuth,
+			ClientSecretAuth, ClientCertificateAuth, ManagedIdentityAuth, WorkloadIdentityAuth, AzureJSONAuth)
+	}
+}
+
+// Authorizer returns an authorizer for ARM requests. The same credentials are used for every subscription.
+func (c CredentialConfig) Authorizer(subscriptionID string) (autorest.Authorizer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c = c.withDefaults()
+	resource := tokenResource(c.Environment)
+
+	if c.Mode == EnvironmentAuth {
+		return environmentAuthorizer(c.Environment, resource)
+	}
+	if c.Mode == AzureJSONAuth {
+		return c.azureJSONAuthorizer(resource)
+	}
+	if c.Mode == ManagedIdentityAuth {
+		return managedIdentityAuthorizer(c.ClientID, resource)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(c.Environment.ActiveDirectoryEndpoint, c.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	var secret adal.ServicePrincipalSecret
+	switch c.Mode {
+	case ClientSecretAuth, ClientCertificateAuth:
+		kubernetesSecret := &kubernetesSecret{reader: c.Secrets, name: c.Secret, certificate: c.Mode == ClientCertificateAuth}
+		// fail now rather than on the first request if the secret can't be used
+		if _, err := kubernetesSecret.read(); err != nil {
+			return nil, err
+		}
+		secret = kubernetesSecret
+	case WorkloadIdentityAuth:
+		secret = &federatedToken{path: c.TokenFile}
+	}
+	token, err := adal.NewServicePrincipalTokenWithSecret(*oauthConfig, c.ClientID, resource, secret)
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(token), nil
+}
+
+// same as auth.NewAuthorizerFromEnvironment, but in the configured cloud rather than the one in AZURE_ENVIRONMENT
+func environmentAuthorizer(env azure.Environment, resource string) (autorest.Authorizer, error) {
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	settings.Environment = env
+	settings.Values[auth.Resource] = resource
+	return settings.GetAuthorizer()
+}
+
+func managedIdentityAuthorizer(clientID, resource string) (autorest.Authorizer, error) {
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	var token *adal.ServicePrincipalToken
+	if clientID == "" {
+		token, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
+	} else {
+		token, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, resource, clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(token), nil
+}
+
+// the settings used from the cloud provider config file
+type azureJSON struct {
+	TenantID                    string `json:"tenantId"`
+	AADClientID                 string `json:"aadClientId"`
+	AADClientSecret             string `json:"aadClientSecret"`
+	AADClientCertPath           string `json:"aadClientCertPath"`
+	AADClientCertPassword       string `json:"aadClientCertPassword"`
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension"`
+	UserAssignedIdentityID      string `json:"userAssignedIdentityID"`
+}
+
+func readAzureJSON(path string) (azureJSON, error) {
+	var config azureJSON
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("invalid cloud provider config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+func (c CredentialConfig) azureJSONAuthorizer(resource string) (autorest.Authorizer, error) {
+	config, err := readAzureJSON(c.AzureJSONPath)
+	if err != nil {
+		return nil, err
+	}
+	if config.UseManagedIdentityExtension {
+		return managedIdentityAuthorizer(config.UserAssignedIdentityID, resource)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(c.Environment.ActiveDirectoryEndpoint, config.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	var token *adal.ServicePrincipalToken
+	switch {
+	case config.AADClientSecret != "":
+		token, err = adal.NewServicePrincipalToken(*oauthConfig, config.AADClientID, config.AADClientSecret, resource)
+	case config.AADClientCertPath != "":
+		var certificate *x509.Certificate
+		var privateKey *rsa.PrivateKey
+		certificate, privateKey, err = readCertificate(config.AADClientCertPath, config.AADClientCertPassword)
+		if err != nil {
+			return nil, err
+		}
+		token, err = adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, config.AADClientID, certificate, privateKey, resource)
+	default:
+		return nil, fmt.Errorf("cloud provider config %s has no managed identity, client secret or certificate", c.AzureJSONPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(token), nil
+}
+
+func readCertificate(path, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodePkcs12(b, password)
+}
+
+func decodePkcs12(b []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	privateKey, certificate, err := pkcs12.Decode(b, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode client certificate: %v", err)
+	}
+	rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("client certificate private key isn't an RSA key")
+	}
+	return certificate, rsaPrivateKey, nil
+}
+
+// kubernetesSecret authenticates with a client secret or certificate that's read from a Kubernetes Secret each
+// time the token is refreshed, so rotated credentials are used once the current token expires
+type kubernetesSecret struct {
+	reader      client.Reader
+	name        types.NamespacedName
+	certificate bool
+}
+
+func (s *kubernetesSecret) read() (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretReadTimeout)
+	defer cancel()
+	var secret corev1.Secret
+	if err := s.reader.Get(ctx, s.name, &secret); err != nil {
+		return nil, fmt.Errorf("failed to read credentials secret %s: %v", s.name, err)
+	}
+	key := ClientSecretKey
+	if s.certificate {
+		key = ClientCertificateKey
+	}
+	if len(secret.Data[key]) == 0 {
+		return nil, fmt.Errorf("credentials secret %s has no %s", s.name, key)
+	}
+	return secret.Data, nil
+}
+
+func (s *kubernetesSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	if !s.certificate {
+		v.Set("client_secret", string(data[ClientSecretKey]))
+		return nil
+	}
+	certificate, privateKey, err := decodePkcs12(data[ClientCertificateKey], string(data[ClientCertificatePasswordKey]))
+	if err != nil {
+		return err
+	}
+	secret := &adal.ServicePrincipalCertificateSecret{Certificate: certificate, PrivateKey: privateKey}
+	return secret.SetAuthenticationValues(spt, v)
+}
+
+// federatedToken authenticates with a service account token projected into a file, which is read each time
+// the Azure AD token is refreshed since the kubelet rotates it
+type federatedToken struct {
+	path string
+}
+
+func (t *federatedToken) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	b, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to read federated token: %v", err)
+	}
+	v.Set("client_assertion_type", clientAssertionType)
+	v.Set("client_assertion", strings.TrimSpace(string(b)))
+	return nil
+}
+
+// SubscriptionCredentials uses the credentials mapped to a subscription, and Default for other subscriptions
+type SubscriptionCredentials struct {
+	Default Credentials
+	// Subscriptions maps lowercase subscription IDs to their credentials
+	Subscriptions map[string]Credentials
+}
+
+var _ Credentials = &SubscriptionCredentials{}
+
+func (s *SubscriptionCredentials) Authorizer(subscriptionID string) (autorest.Authorizer, error) {
+	if credentials, ok := s.Subscriptions[strings.ToLower(subscriptionID)]; ok {
+		return credentials.Authorizer(subscriptionID)
+	}
+	return s.Default.Authorizer(subscriptionID)
+}
+
+// credentials of a subscription in the file read by LoadSubscriptionCredentials
+type subscriptionCredentialConfig struct {
+	AuthMode           AuthMode `json:"authMode"`
+	TenantID           string   `json:"tenantId"`
+	ClientID           string   `json:"clientId"`
+	CredentialsSecret  string   `json:"credentialsSecret"`
+	FederatedTokenFile string   `json:"federatedTokenFile"`
+	AzureJSONPath      string   `json:"azureJSONPath"`
+}
+
+// LoadSubscriptionCredentials reads a JSON file mapping subscription IDs to the credentials used for them, ex:
+//
+//	{"00000000-0000-0000-0000-000000000000": {"authMode": "client-secret", "tenantId": "...", "clientId": "...",
+//	    "credentialsSecret": "node-label-operator-system/other-subscription"}}
+//
+// Subscriptions that aren't in the file use defaults. Mapped subscriptions share the cloud and secret reader
+// of defaults.
+func LoadSubscriptionCredentials(path string, defaults CredentialConfig) (*SubscriptionCredentials, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs map[string]subscriptionCredentialConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("invalid subscription credentials %s: %v", path, err)
+	}
+
+	credentials := &SubscriptionCredentials{Default: defaults, Subscriptions: map[string]Credentials{}}
+	for subscriptionID, config := range configs {
+		c := CredentialConfig{
+			Mode:          config.AuthMode,
+			TenantID:      config.TenantID,
+			ClientID:      config.ClientID,
+			Secrets:       defaults.Secrets,
+			TokenFile:     config.FederatedTokenFile,
+			AzureJSONPath: config.AzureJSONPath,
+			Environment:   defaults.Environment,
+		}
+		if config.CredentialsSecret != "" {
+			if c.Secret, err = ParseSecretName(config.CredentialsSecret); err != nil {
+				return nil, fmt.Errorf("invalid credentials of subscription %s: %v", subscriptionID, err)
+			}
+		}
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid credentials of subscription %s: %v", subscriptionID, err)
+		}
+		credentials.Subscriptions[strings.ToLower(subscriptionID)] = c
+	}
+	return credentials, nil
+}
+
+// ParseSecretName parses the name of a Secret given as namespace/name
+func ParseSecretName(s string) (types.NamespacedName, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, fmt.Errorf("invalid secret %q, must be namespace/name", s)
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, nil
+}
@@ -0,0 +1,158 @@
+package azure
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCredentialConfigValidate(t *testing.T) {
+	secretReader := ctrlfake.NewFakeClient()
+	secret := types.NamespacedName{Namespace: "node-label-operator-system", Name: "azure-credentials"}
+	var validateTest = []struct {
+		name      string
+		config    CredentialConfig
+		expectErr bool
+	}{
+		{"default", CredentialConfig{}, false},
+		{"managed identity", CredentialConfig{Mode: ManagedIdentityAuth}, false},
+		{"azure json", CredentialConfig{Mode: AzureJSONAuth}, false},
+		{"client secret", CredentialConfig{Mode: ClientSecretAuth, TenantID: "tenant", ClientID: "client", Secret: secret, Secrets: secretReader}, false},
+		{"client secret without secret", CredentialConfig{Mode: ClientSecretAuth, TenantID: "tenant", ClientID: "client", Secrets: secretReader}, true},
+		{"client certificate without client ID", CredentialConfig{Mode: ClientCertificateAuth, TenantID: "tenant", Secret: secret, Secrets: secretReader}, true},
+		{"workload identity", CredentialConfig{Mode: WorkloadIdentityAuth, TenantID: "tenant", ClientID: "client", TokenFile: "/var/run/token"}, false},
+		{"workload identity without token", CredentialConfig{Mode: WorkloadIdentityAuth, TenantID: "tenant", ClientID: "client"}, true},
+		{"invalid mode", CredentialConfig{Mode: "password"}, true},
+	}
+
+	os.Unsetenv("AZURE_FEDERATED_TOKEN_FILE")
+	for _, tt := range validateTest {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKubernetesSecretRotation(t *testing.T) {
+	name := types.NamespacedName{Namespace: "node-label-operator-system", Name: "azure-credentials"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+		Data:       map[string][]byte{ClientSecretKey: []byte("first")},
+	}
+	reader := ctrlfake.NewFakeClient(secret)
+	s := &kubernetesSecret{reader: reader, name: name}
+
+	v := url.Values{}
+	require.NoError(t, s.SetAuthenticationValues(nil, &v))
+	assert.Equal(t, "first", v.Get("client_secret"))
+
+	// the rotated secret is used on the next refresh
+	secret.Data[ClientSecretKey] = []byte("second")
+	require.NoError(t, reader.Update(context.Background(), secret))
+	require.NoError(t, s.SetAuthenticationValues(nil, &v))
+	assert.Equal(t, "second", v.Get("client_secret"))
+
+	// a certificate is required in certificate mode
+	s.certificate = true
+	assert.Error(t, s.SetAuthenticationValues(nil, &v))
+}
+
+func TestFederatedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	token := &federatedToken{path: path}
+	v := url.Values{}
+	assert.Error(t, token.SetAuthenticationValues(nil, &v))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("header.payload.signature\n"), 0600))
+	require.NoError(t, token.SetAuthenticationValues(nil, &v))
+	assert.Equal(t, clientAssertionType, v.Get("client_assertion_type"))
+	assert.Equal(t, "header.payload.signature", v.Get("client_assertion"))
+}
+
+func TestAzureJSONAuthorizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "azure.json")
+	config := CredentialConfig{Mode: AzureJSONAuth, AzureJSONPath: path}
+
+	// missing file
+	_, err = config.Authorizer("sub")
+	assert.Error(t, err)
+
+	// no credentials in file
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"tenantId": "tenant", "aadClientId": "client"}`), 0600))
+	_, err = config.Authorizer("sub")
+	assert.Error(t, err)
+
+	// client secret
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"tenantId": "tenant", "aadClientId": "client", "aadClientSecret": "secret"}`), 0600))
+	authorizer, err := config.Authorizer("sub")
+	assert.NoError(t, err)
+	assert.NotNil(t, authorizer)
+}
+
+func TestLoadSubscriptionCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.json")
+	reader := ctrlfake.NewFakeClient()
+	defaults := CredentialConfig{Mode: ManagedIdentityAuth, Secrets: reader}
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+	"AAAA": {"authMode": "client-secret", "tenantId": "tenant", "clientId": "client", "credentialsSecret": "ns/other-subscription"},
+	"bbbb": {"authMode": "managed-identity", "clientId": "identity"}
+}`), 0600))
+	credentials, err := LoadSubscriptionCredentials(path, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, defaults, credentials.Default)
+	assert.Equal(t, CredentialConfig{
+		Mode:     ClientSecretAuth,
+		TenantID: "tenant",
+		ClientID: "client",
+		Secret:   types.NamespacedName{Namespace: "ns", Name: "other-subscription"},
+		Secrets:  reader,
+	}, credentials.Subscriptions["aaaa"])
+	assert.Equal(t, CredentialConfig{Mode: ManagedIdentityAuth, ClientID: "identity", Secrets: reader}, credentials.Subscriptions["bbbb"])
+
+	// the mapped secret doesn't exist
+	_, err = credentials.Authorizer("AAAA")
+	assert.Error(t, err)
+
+	var invalidTest = []struct {
+		name    string
+		content string
+	}{
+		{"invalid JSON", `{"aaaa": `},
+		{"invalid secret name", `{"aaaa": {"authMode": "client-secret", "tenantId": "tenant", "clientId": "client", "credentialsSecret": "other-subscription"}}`},
+		{"missing client ID", `{"aaaa": {"authMode": "client-secret", "tenantId": "tenant", "credentialsSecret": "ns/other-subscription"}}`},
+		{"invalid mode", `{"aaaa": {"authMode": "password"}}`},
+	}
+	for _, tt := range invalidTest {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.content), 0600))
+			_, err := LoadSubscriptionCredentials(path, defaults)
+			assert.Error(t, err)
+		})
+	}
+}
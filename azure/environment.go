@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package azure
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// LoadEnvironment returns the endpoints of the Azure cloud the operator talks to. A custom cloud, such as
+// Azure Stack Hub, is described by a JSON file of endpoints in the go-autorest Environment format. Otherwise
+// the cloud is chosen by name, ex: AzureUSGovernmentCloud or AzureChinaCloud. With neither, the name is taken from
+// AZURE_ENVIRONMENT, as the Azure SDKs do, and it's the public cloud if that isn't set either.
+func LoadEnvironment(name, file string) (azure.Environment, error) {
+	if file != "" {
+		if name != "" {
+			return azure.Environment{}, errors.New("a cloud can't be given both by name and by endpoint file")
+		}
+		env, err := azure.EnvironmentFromFile(file)
+		if err != nil {
+			return azure.Environment{}, err
+		}
+		if env.ResourceManagerEndpoint == "" || env.ActiveDirectoryEndpoint == "" {
+			return azure.Environment{}, errors.New("cloud endpoint file must set resourceManagerEndpoint and activeDirectoryEndpoint")
+		}
+		return env, nil
+	}
+	if name == "" {
+		name = os.Getenv(auth.EnvironmentName)
+	}
+	if name == "" {
+		return azure.PublicCloud, nil
+	}
+	return azure.EnvironmentFromName(name)
+}
+
+// the zero Environment is the public cloud
+func environmentOrDefault(env azure.Environment) azure.Environment {
+	if env.ResourceManagerEndpoint == "" {
+		return azure.PublicCloud
+	}
+	return env
+}
+
+// base URI of ARM clients, without the trailing slash of the environment's endpoint
+func resourceManagerURI(env azure.Environment) string {
+	return strings.TrimSuffix(environmentOrDefault(env).ResourceManagerEndpoint, "/")
+}
+
+// resource that tokens for ARM are requested for. Azure Stack Hub uses a token audience that's different
+// from its ARM endpoint.
+func tokenResource(env azure.Environment) string {
+	env = environmentOrDefault(env)
+	if env.TokenAudience != "" {
+		return env.TokenAudience
+	}
+	return env.ResourceManagerEndpoint
+}
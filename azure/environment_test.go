@@ -0,0 +1,90 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "environment")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stackFile := filepath.Join(dir, "azurestackcloud.json")
+	require.NoError(t, ioutil.WriteFile(stackFile, []byte(`{
+	"name": "AzureStackCloud",
+	"resourceManagerEndpoint": "https://management.local.azurestack.external/",
+	"activeDirectoryEndpoint": "https://adfs.local.azurestack.external/adfs/",
+	"tokenAudience": "https://management.adfs.azurestack.local/4de154de-f8a8-4017-af41-df619da68155"
+}`), 0600))
+	incompleteFile := filepath.Join(dir, "incomplete.json")
+	require.NoError(t, ioutil.WriteFile(incompleteFile, []byte(`{"name": "AzureStackCloud"}`), 0600))
+	defer os.Setenv("AZURE_ENVIRONMENT", os.Getenv("AZURE_ENVIRONMENT"))
+	require.NoError(t, os.Unsetenv("AZURE_ENVIRONMENT"))
+
+	var environmentTest = []struct {
+		name                string
+		cloud               string
+		file                string
+		expectErr           bool
+		expectedARM         string
+		expectedARMURI      string
+		expectedResource    string
+		expectedADAuthority string
+	}{
+		{"default", "", "", false, "https://management.azure.com/", "https://management.azure.com",
+			"https://management.azure.com/", "https://login.microsoftonline.com/"},
+		{"government", "AzureUSGovernmentCloud", "", false, "https://management.usgovcloudapi.net/", "https://management.usgovcloudapi.net",
+			"https://management.usgovcloudapi.net/", "https://login.microsoftonline.us/"},
+		{"china", "AzureChinaCloud", "", false, "https://management.chinacloudapi.cn/", "https://management.chinacloudapi.cn",
+			"https://management.chinacloudapi.cn/", "https://login.chinacloudapi.cn/"},
+		{"azure stack", "", stackFile, false, "https://management.local.azurestack.external/", "https://management.local.azurestack.external",
+			"https://management.adfs.azurestack.local/4de154de-f8a8-4017-af41-df619da68155", "https://adfs.local.azurestack.external/adfs/"},
+		{"unknown name", "AzureMoonCloud", "", true, "", "", "", ""},
+		{"name and file", "AzureChinaCloud", stackFile, true, "", "", "", ""},
+		{"missing file", "", filepath.Join(dir, "missing.json"), true, "", "", "", ""},
+		{"incomplete file", "", incompleteFile, true, "", "", "", ""},
+	}
+
+	for _, tt := range environmentTest {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := LoadEnvironment(tt.cloud, tt.file)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedARM, env.ResourceManagerEndpoint)
+			assert.Equal(t, tt.expectedARMURI, resourceManagerURI(env))
+			assert.Equal(t, tt.expectedResource, tokenResource(env))
+			assert.Equal(t, tt.expectedADAuthority, env.ActiveDirectoryEndpoint)
+		})
+	}
+
+	// the zero environment is the public cloud
+	assert.Equal(t, azure.PublicCloud, environmentOrDefault(azure.Environment{}))
+}
+
+func TestLoadEnvironmentFromVariable(t *testing.T) {
+	defer os.Setenv("AZURE_ENVIRONMENT", os.Getenv("AZURE_ENVIRONMENT"))
+	require.NoError(t, os.Setenv("AZURE_ENVIRONMENT", "AzureChinaCloud"))
+
+	// used when no cloud is given
+	env, err := LoadEnvironment("", "")
+	require.NoError(t, err)
+	assert.Equal(t, azure.ChinaCloud.ResourceManagerEndpoint, env.ResourceManagerEndpoint)
+
+	// a cloud given by name takes precedence
+	env, err = LoadEnvironment("AzureUSGovernmentCloud", "")
+	require.NoError(t, err)
+	assert.Equal(t, azure.USGovernmentCloud.ResourceManagerEndpoint, env.ResourceManagerEndpoint)
+
+	require.NoError(t, os.Setenv("AZURE_ENVIRONMENT", "AzureMoonCloud"))
+	_, err = LoadEnvironment("", "")
+	assert.Error(t, err)
+}
@@ -4,7 +4,13 @@
 package azure
 
 import (
+	"fmt"
+	"net/http"
+	"regexp"
+
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 )
 
 func IsNotFound(err error) bool {
@@ -13,3 +19,90 @@ func IsNotFound(err error) bool {
 	}
 	return false
 }
+
+// IsPreconditionFailed is true if a write was rejected because the resource changed since it was read
+func IsPreconditionFailed(err error) bool {
+	if derr, ok := err.(autorest.DetailedError); ok && derr.StatusCode == http.StatusPreconditionFailed {
+		return true
+	}
+	return false
+}
+
+// CredentialsError is returned when no authorizer could be created for a subscription
+type CredentialsError struct {
+	SubscriptionID string
+	Err            error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("no usable credentials for subscription %s: %v", e.SubscriptionID, e.Err)
+}
+
+// reasons a whole subscription can't be synced
+const (
+	InvalidCredentialsReason   string = "InvalidCredentials"
+	UnauthorizedReason         string = "Unauthorized"
+	ForbiddenReason            string = "Forbidden"
+	SubscriptionNotFoundReason string = "SubscriptionNotFound"
+)
+
+// code of ARM errors for requests the identity has no role assignment for
+const authorizationFailedCode string = "AuthorizationFailed"
+
+var (
+	// scope named in the message of AuthorizationFailed errors, ex: "The client '...' with object id '...' does not
+	// have authorization to perform action '...' over scope '/subscriptions/...' or the scope is invalid."
+	authorizationFailedScope = regexp.MustCompile(`over scope '([^']*)'`)
+	subscriptionScope        = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/?$`)
+)
+
+// SubscriptionErrorReason returns why an error affects every resource in the subscription rather than one
+// resource, ex: because credentials are missing or the identity has no role in the subscription. ok is false
+// for other errors, including 403s for a resource group or resource, which HealthTracker counts across resources.
+func SubscriptionErrorReason(err error) (reason string, ok bool) {
+	if _, ok := err.(*CredentialsError); ok {
+		return InvalidCredentialsReason, true
+	}
+	derr, ok := err.(autorest.DetailedError)
+	if !ok {
+		return "", false
+	}
+	// the error of the authorizer or ARM is wrapped by the client that sent the request
+	for {
+		if _, ok := derr.Original.(adal.TokenRefreshError); ok {
+			return InvalidCredentialsReason, true
+		}
+		if rerr, ok := derr.Original.(*azure.RequestError); ok && rerr.ServiceError != nil {
+			switch rerr.ServiceError.Code {
+			case SubscriptionNotFoundReason:
+				return SubscriptionNotFoundReason, true
+			case authorizationFailedCode:
+				if match := authorizationFailedScope.FindStringSubmatch(rerr.ServiceError.Message); match != nil &&
+					subscriptionScope.MatchString(match[1]) {
+					return ForbiddenReason, true
+				}
+				return "", false
+			}
+		}
+		if derr.StatusCode == http.StatusUnauthorized {
+			return UnauthorizedReason, true
+		}
+		inner, ok := derr.Original.(autorest.DetailedError)
+		if !ok {
+			return "", false
+		}
+		derr = inner
+	}
+}
+
+// IsForbidden is true if ARM refused a request because the identity isn't allowed to make it
+func IsForbidden(err error) bool {
+	derr, ok := err.(autorest.DetailedError)
+	for ok {
+		if derr.StatusCode == http.StatusForbidden {
+			return true
+		}
+		derr, ok = derr.Original.(autorest.DetailedError)
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package azure
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubscriptionHealth is whether resources in a subscription can be synced
+type SubscriptionHealth struct {
+	SubscriptionID string
+	Healthy        bool
+	// Reason and Message describe the error of an unhealthy subscription, see SubscriptionErrorReason
+	Reason  string
+	Message string
+	// Since is when the subscription became healthy or unhealthy
+	Since time.Time
+}
+
+// number of different resources in a subscription that must be forbidden, with no resource synced since, before
+// the subscription is unhealthy. ARM names the resource rather than the subscription in the AuthorizationFailed
+// errors of an identity without any role in the subscription, so a single 403 can't tell them apart.
+const forbiddenResourcesThreshold int = 3
+
+// HealthTracker follows the health of each subscription from the results of syncing its resources. While a
+// subscription is unhealthy, only one request is let through per backoff period to check if it recovered,
+// rather than every node in the subscription failing the same way. Safe for concurrent use.
+type HealthTracker struct {
+	lock          sync.Mutex
+	subscriptions map[string]*subscriptionHealth
+	// keys of the resources forbidden since a resource of the subscription was last synced, by subscription
+	forbidden map[string]map[string]bool
+	backoff   time.Duration
+	now       func() time.Time
+}
+
+type subscriptionHealth struct {
+	SubscriptionHealth
+	retryAt time.Time
+}
+
+// NewHealthTracker returns a HealthTracker that checks unhealthy subscriptions again after backoff
+func NewHealthTracker(backoff time.Duration) *HealthTracker {
+	return &HealthTracker{
+		subscriptions: map[string]*subscriptionHealth{},
+		forbidden:     map[string]map[string]bool{},
+		backoff:       backoff,
+		now:           time.Now,
+	}
+}
+
+// Allow returns how long to wait before syncing resources in the subscription, 0 if they can be synced now.
+// Once the backoff of an unhealthy subscription has passed, the caller that's allowed through is the only one
+// until the next backoff. Nil-safe.
+func (h *HealthTracker) Allow(subscriptionID string) time.Duration {
+	if h == nil {
+		return 0
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s, ok := h.subscriptions[strings.ToLower(subscriptionID)]
+	if !ok || s.Healthy {
+		return 0
+	}
+	now := h.now()
+	if wait := s.retryAt.Sub(now); wait > 0 {
+		return wait
+	}
+	s.retryAt = now.Add(h.backoff)
+	return 0
+}
+
+// Observe records the result of syncing the resource with the given key in the subscription. Errors that only
+// affect the one resource don't change the health of the subscription, except 403s: once enough different
+// resources are forbidden, with none synced in between, the identity is taken to have no role in the
+// subscription. It returns the health of the subscription, and whether it changed, which includes the first
+// time the subscription is seen. Nil-safe.
+func (h *HealthTracker) Observe(subscriptionID, resourceKey string, err error) (SubscriptionHealth, bool) {
+	if h == nil {
+		return SubscriptionHealth{SubscriptionID: subscriptionID, Healthy: true}, false
+	}
+	reason, unhealthy := SubscriptionErrorReason(err)
+	forbidden := err != nil && !unhealthy && IsForbidden(err)
+	if err != nil && !unhealthy && !forbidden {
+		return h.Get(subscriptionID), false
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := strings.ToLower(subscriptionID)
+	if err == nil {
+		delete(h.forbidden, key)
+	}
+	if forbidden {
+		if h.forbidden[key] == nil {
+			h.forbidden[key] = map[string]bool{}
+		}
+		h.forbidden[key][strings.ToLower(resourceKey)] = true
+		if len(h.forbidden[key]) < forbiddenResourcesThreshold {
+			if s, ok := h.subscriptions[key]; ok {
+				return s.SubscriptionHealth, false
+			}
+			return SubscriptionHealth{SubscriptionID: subscriptionID, Healthy: true}, false
+		}
+		reason, unhealthy = ForbiddenReason, true
+	}
+	s, ok := h.subscriptions[key]
+	if !ok {
+		s = &subscriptionHealth{}
+		h.subscriptions[key] = s
+	}
+	now := h.now()
+	if unhealthy {
+		s.retryAt = now.Add(h.backoff)
+	}
+	if ok && s.Healthy == !unhealthy && s.Reason == reason {
+		return s.SubscriptionHealth, false
+	}
+
+	s.SubscriptionHealth = SubscriptionHealth{SubscriptionID: subscriptionID, Healthy: !unhealthy, Reason: reason, Since: now}
+	if unhealthy {
+		s.Message = err.Error()
+	}
+	healthySubscription.WithLabelValues(key).Set(boolToFloat(s.Healthy))
+	return s.SubscriptionHealth, true
+}
+
+// Get returns the health of the subscription. Subscriptions that haven't been synced yet are healthy.
+func (h *HealthTracker) Get(subscriptionID string) SubscriptionHealth {
+	if h == nil {
+		return SubscriptionHealth{SubscriptionID: subscriptionID, Healthy: true}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if s, ok := h.subscriptions[strings.ToLower(subscriptionID)]; ok {
+		return s.SubscriptionHealth
+	}
+	return SubscriptionHealth{SubscriptionID: subscriptionID, Healthy: true}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
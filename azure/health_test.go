@@ -0,0 +1,162 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+)
+
+func armError(statusCode int, code string) error {
+	return armErrorWithMessage(statusCode, code, "")
+}
+
+func armErrorWithMessage(statusCode int, code, message string) error {
+	requestErr := &azure.RequestError{
+		DetailedError: autorest.DetailedError{StatusCode: statusCode},
+		ServiceError:  &azure.ServiceError{Code: code, Message: message},
+	}
+	return autorest.NewErrorWithError(requestErr, "compute.VirtualMachinesClient", "Get",
+		&http.Response{StatusCode: statusCode}, "Failure responding to request")
+}
+
+// AuthorizationFailed error of an identity without a role assignment for the scope
+func forbiddenError(scope string) error {
+	return armErrorWithMessage(http.StatusForbidden, "AuthorizationFailed", "The client 'id' with object id 'id' does not have "+
+		"authorization to perform action 'Microsoft.Compute/virtualMachines/read' over scope '"+scope+"' or the scope is invalid.")
+}
+
+func TestSubscriptionErrorReason(t *testing.T) {
+	tokenErr := autorest.NewErrorWithError(
+		autorest.NewErrorWithError(errors.New("bad secret"), "azure.BearerAuthorizer", "WithAuthorization", nil, "Failed to refresh the Token"),
+		"compute.VirtualMachinesClient", "Get", nil, "Failure preparing request")
+
+	var reasonTest = []struct {
+		name     string
+		err      error
+		expectOk bool
+		expected string
+	}{
+		{"no credentials", &CredentialsError{SubscriptionID: "sub", Err: errors.New("missing secret")}, true, InvalidCredentialsReason},
+		{"forbidden in subscription", forbiddenError("/subscriptions/sub"), true, ForbiddenReason},
+		{"forbidden in resource group", forbiddenError("/subscriptions/sub/resourceGroups/rg"), false, ""},
+		{"forbidden on resource", forbiddenError("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"), false, ""},
+		{"forbidden without scope", armError(http.StatusForbidden, "AuthorizationFailed"), false, ""},
+		{"forbidden by policy", armError(http.StatusForbidden, "RequestDisallowedByPolicy"), false, ""},
+		{"unauthorized", armError(http.StatusUnauthorized, "InvalidAuthenticationToken"), true, UnauthorizedReason},
+		{"subscription not found", armError(http.StatusNotFound, "SubscriptionNotFound"), true, SubscriptionNotFoundReason},
+		{"resource not found", armError(http.StatusNotFound, "ResourceNotFound"), false, ""},
+		{"throttled", armError(http.StatusTooManyRequests, "TooManyRequests"), false, ""},
+		{"token not refreshed for other reasons", tokenErr, false, ""},
+		{"other error", errors.New("connection reset"), false, ""},
+		{"no error", nil, false, ""},
+	}
+
+	for _, tt := range reasonTest {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := SubscriptionErrorReason(tt.err)
+			assert.Equal(t, tt.expectOk, ok)
+			assert.Equal(t, tt.expected, reason)
+		})
+	}
+}
+
+func TestHealthTracker(t *testing.T) {
+	tracker := NewHealthTracker(5 * time.Minute)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	// first sync
+	health, changed := tracker.Observe("Sub", "vm1", nil)
+	assert.True(t, changed)
+	assert.True(t, health.Healthy)
+	_, changed = tracker.Observe("sub", "vm1", nil)
+	assert.False(t, changed)
+
+	// errors of one resource don't change health
+	_, changed = tracker.Observe("sub", "vm1", armError(http.StatusNotFound, "ResourceNotFound"))
+	assert.False(t, changed)
+	_, changed = tracker.Observe("sub", "vm1", forbiddenError("/subscriptions/sub/resourceGroups/rg"))
+	assert.False(t, changed)
+	assert.Equal(t, time.Duration(0), tracker.Allow("sub"))
+
+	// missing RBAC makes the subscription unhealthy once
+	health, changed = tracker.Observe("sub", "vm1", forbiddenError("/subscriptions/sub"))
+	assert.True(t, changed)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, ForbiddenReason, health.Reason)
+	assert.Equal(t, now, health.Since)
+	_, changed = tracker.Observe("sub", "vm1", forbiddenError("/subscriptions/sub"))
+	assert.False(t, changed)
+	assert.False(t, tracker.Get("SUB").Healthy)
+
+	// nodes wait out the backoff, then one is let through
+	assert.Equal(t, 5*time.Minute, tracker.Allow("sub"))
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, time.Duration(0), tracker.Allow("sub"))
+	assert.Equal(t, 5*time.Minute, tracker.Allow("sub"))
+
+	// recovery
+	health, changed = tracker.Observe("sub", "vm1", nil)
+	assert.True(t, changed)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, time.Duration(0), tracker.Allow("sub"))
+
+	// other subscriptions are tracked separately
+	assert.True(t, tracker.Get("sub2").Healthy)
+}
+
+func TestHealthTrackerForbiddenResources(t *testing.T) {
+	tracker := NewHealthTracker(5 * time.Minute)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	scaleSet := func(name string) string {
+		return "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/" + name
+	}
+	// ARM names the scale set as the scope when the identity has no role in the subscription
+	observe := func(name string) (SubscriptionHealth, bool) {
+		return tracker.Observe("sub", strings.ToLower(scaleSet(name)), forbiddenError(scaleSet(name)))
+	}
+
+	_, changed := tracker.Observe("sub", "vm1", nil)
+	assert.True(t, changed)
+
+	// the same resource forbidden again, or one other resource, could be a narrower role assignment
+	for _, name := range []string{"pool1", "pool1", "pool2"} {
+		health, changed := observe(name)
+		assert.False(t, changed)
+		assert.True(t, health.Healthy)
+	}
+	// a resource synced in between means the identity has a role in the subscription
+	_, changed = tracker.Observe("sub", "vm1", nil)
+	assert.False(t, changed)
+	health, changed := observe("pool3")
+	assert.False(t, changed)
+	assert.True(t, health.Healthy)
+	observe("pool4")
+
+	health, changed = observe("pool5")
+	assert.True(t, changed)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, ForbiddenReason, health.Reason)
+	assert.Equal(t, 5*time.Minute, tracker.Allow("sub"))
+	_, changed = observe("pool1")
+	assert.False(t, changed)
+
+	health, changed = tracker.Observe("sub", "vm1", nil)
+	assert.True(t, changed)
+	assert.True(t, health.Healthy)
+}
+
+func TestIsForbidden(t *testing.T) {
+	assert.True(t, IsForbidden(forbiddenError("/subscriptions/sub/resourceGroups/rg")))
+	assert.True(t, IsForbidden(armError(http.StatusForbidden, "RequestDisallowedByPolicy")))
+	assert.False(t, IsForbidden(armError(http.StatusNotFound, "ResourceNotFound")))
+	assert.False(t, IsForbidden(errors.New("connection reset")))
+	assert.False(t, IsForbidden(nil))
+}
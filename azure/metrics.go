@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package azure
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_label_operator_arm_ratelimit_remaining",
+		Help: "Requests left in an ARM throttling window, as last reported by ARM",
+	}, []string{"subscription", "quota"})
+
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_label_operator_arm_throttled_requests_total",
+		Help: "Requests throttled by ARM with 429 Too Many Requests",
+	}, []string{"subscription"})
+
+	healthySubscription = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_label_operator_subscription_healthy",
+		Help: "Whether resources in a subscription can be synced, 0 when credentials are invalid or lack permissions",
+	}, []string{"subscription"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(rateLimitRemaining, throttledRequests, healthySubscription)
+}
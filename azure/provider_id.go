@@ -17,6 +17,8 @@ type Resource struct {
 	Provider       string
 	ResourceType   string
 	ResourceName   string
+	// InstanceID is the VMSS instance the node runs on, empty for VMs
+	InstanceID string
 }
 
 func ParseProviderID(providerID string) (Resource, error) {
@@ -44,6 +46,16 @@ func parseResourceID(resourceID string) (Resource, error) {
 		ResourceType:   match[4],
 		ResourceName:   v[0],
 	}
+	if len(v) > 2 && strings.EqualFold(v[1], "virtualMachines") {
+		result.InstanceID = v[2]
+	}
 
 	return result, nil
 }
+
+// Key identifies the VM or VMSS, and is the same for all nodes running on it. It's the resource ID in lowercase,
+// ex: /subscriptions/<id>/resourcegroups/<group>/providers/microsoft.compute/virtualmachinescalesets/<name>
+func (r Resource) Key() string {
+	return strings.ToLower(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s",
+		r.SubscriptionID, r.ResourceGroup, r.Provider, r.ResourceType, r.ResourceName))
+}
@@ -21,6 +21,7 @@ func TestParseProviderId(t *testing.T) {
 				Provider:       "Microsoft.Compute",
 				ResourceType:   "virtualMachineScaleSets",
 				ResourceName:   "<vmss>",
+				InstanceID:     "0",
 			},
 		},
 		{
@@ -58,6 +59,20 @@ func TestParseProviderId(t *testing.T) {
 			assert.Equal(t, resource.Provider, tt.expected.Provider)
 			assert.Equal(t, resource.ResourceType, tt.expected.ResourceType)
 			assert.Equal(t, resource.ResourceName, tt.expected.ResourceName)
+			assert.Equal(t, resource.InstanceID, tt.expected.InstanceID)
 		})
 	}
 }
+
+func TestResourceKey(t *testing.T) {
+	instance0, err := ParseProviderID("azure:///subscriptions/Sub/resourceGroups/MC_rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool1/virtualMachines/0")
+	assert.NoError(t, err)
+	instance1, err := ParseProviderID("azure:///subscriptions/sub/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool1/virtualMachines/1")
+	assert.NoError(t, err)
+	vm, err := ParseProviderID("azure:///subscriptions/sub/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachines/pool1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/subscriptions/sub/resourcegroups/mc_rg/providers/microsoft.compute/virtualmachinescalesets/pool1", instance0.Key())
+	assert.Equal(t, instance0.Key(), instance1.Key())
+	assert.NotEqual(t, instance0.Key(), vm.Key())
+}
@@ -0,0 +1,240 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ARM reports the requests left in each throttling window in headers with this prefix, ex:
+	// x-ms-ratelimit-remaining-subscription-reads: 11999 or, for resource providers,
+	// x-ms-ratelimit-remaining-resource: Microsoft.Compute/HighCostGet3Min;139,Microsoft.Compute/HighCostGet30Min;699
+	rateLimitRemainingPrefix string = "x-ms-ratelimit-remaining-"
+	// back off once fewer requests than this are left in any window
+	lowRemainingQuota int = 20
+	// the rate is never slowed below this fraction of the configured rate
+	minRateFraction float64 = 0.05
+	// wait this long after a 429 without a Retry-After header
+	defaultRetryAfter time.Duration = 30 * time.Second
+)
+
+// RateLimiter is a token bucket for ARM reads and another for writes in each subscription, shared by all
+// clients. It waits out Retry-After after a 429, and slows down (halving the rate) when ARM reports that
+// little quota is left or throttles a request, speeding back up while quota is plentiful.
+type RateLimiter struct {
+	readLimit     rate.Limit
+	writeLimit    rate.Limit
+	burst         int
+	lock          sync.Mutex
+	subscriptions map[string]*subscriptionLimiter
+	now           func() time.Time
+}
+
+type subscriptionLimiter struct {
+	reads      *rate.Limiter
+	writes     *rate.Limiter
+	retryAfter time.Time // no requests are sent before this
+}
+
+// ValidateRateLimits checks the rates and burst given to NewRateLimiter. Requests would never be let through
+// with a burst of 0, and a rate of 0 or less would keep requests waiting until their deadline.
+func ValidateRateLimits(readQPS, writeQPS float64, burst int) error {
+	if readQPS <= 0 {
+		return fmt.Errorf("ARM read rate must be greater than 0, got %v", readQPS)
+	}
+	if writeQPS <= 0 {
+		return fmt.Errorf("ARM write rate must be greater than 0, got %v", writeQPS)
+	}
+	if burst < 1 {
+		return fmt.Errorf("ARM burst must be at least 1, got %d", burst)
+	}
+	return nil
+}
+
+// NewRateLimiter returns a limiter allowing readQPS reads and writeQPS writes per second in each subscription,
+// with bursts of up to burst requests
+func NewRateLimiter(readQPS, writeQPS float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		readLimit:     rate.Limit(readQPS),
+		writeLimit:    rate.Limit(writeQPS),
+		burst:         burst,
+		subscriptions: map[string]*subscriptionLimiter{},
+		now:           time.Now,
+	}
+}
+
+// SendDecorator limits the requests sent for the subscription and observes ARM's throttling headers
+func (l *RateLimiter) SendDecorator(subscriptionID string) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			write := isWrite(r.Method)
+			if err := l.Wait(r.Context(), subscriptionID, write); err != nil {
+				return nil, err
+			}
+			resp, err := s.Do(r)
+			l.Observe(subscriptionID, write, resp)
+			return resp, err
+		})
+	}
+}
+
+// Wait blocks until a read or write can be sent for the subscription, or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context, subscriptionID string, write bool) error {
+	l.lock.Lock()
+	sub := l.subscription(subscriptionID)
+	limiter := sub.reads
+	if write {
+		limiter = sub.writes
+	}
+	delay := sub.retryAfter.Sub(l.now())
+	l.lock.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return limiter.Wait(ctx)
+}
+
+// Observe adjusts the rate for the subscription from the throttling headers of a response
+func (l *RateLimiter) Observe(subscriptionID string, write bool, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	remaining := RemainingQuota(resp.Header)
+	for quota, count := range remaining {
+		rateLimitRemaining.WithLabelValues(subscriptionID, quota).Set(float64(count))
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sub := l.subscription(subscriptionID)
+	limiter, configured := sub.reads, l.readLimit
+	if write {
+		limiter, configured = sub.writes, l.writeLimit
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		throttledRequests.WithLabelValues(subscriptionID).Inc()
+		retryAfter, ok := RetryAfter(resp.Header, l.now())
+		if !ok {
+			retryAfter = defaultRetryAfter
+		}
+		if until := l.now().Add(retryAfter); until.After(sub.retryAfter) {
+			sub.retryAfter = until
+		}
+		limiter.SetLimit(slower(limiter.Limit(), configured))
+		return
+	}
+
+	for _, count := range remaining {
+		if count < lowRemainingQuota {
+			limiter.SetLimit(slower(limiter.Limit(), configured))
+			return
+		}
+	}
+	limiter.SetLimit(faster(limiter.Limit(), configured))
+}
+
+// Limit returns the current read or write rate for the subscription, in requests per second
+func (l *RateLimiter) Limit(subscriptionID string, write bool) float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sub := l.subscription(subscriptionID)
+	if write {
+		return float64(sub.writes.Limit())
+	}
+	return float64(sub.reads.Limit())
+}
+
+// must be called with the lock held
+func (l *RateLimiter) subscription(subscriptionID string) *subscriptionLimiter {
+	key := strings.ToLower(subscriptionID)
+	sub, ok := l.subscriptions[key]
+	if !ok {
+		sub = &subscriptionLimiter{
+			reads:  rate.NewLimiter(l.readLimit, l.burst),
+			writes: rate.NewLimiter(l.writeLimit, l.burst),
+		}
+		l.subscriptions[key] = sub
+	}
+	return sub
+}
+
+func slower(limit, configured rate.Limit) rate.Limit {
+	if min := configured * rate.Limit(minRateFraction); limit/2 < min {
+		return min
+	}
+	return limit / 2
+}
+
+// speed up by a tenth of the configured rate, so the rate recovers over a few requests
+func faster(limit, configured rate.Limit) rate.Limit {
+	if limit+configured/10 > configured {
+		return configured
+	}
+	return limit + configured/10
+}
+
+func isWrite(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead
+}
+
+// RemainingQuota returns the requests left in each of ARM's throttling windows reported by the headers,
+// keyed by the name of the window, ex: subscription-reads or Microsoft.Compute/HighCostGet3Min
+func RemainingQuota(header http.Header) map[string]int {
+	remaining := map[string]int{}
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, rateLimitRemainingPrefix) || len(values) == 0 {
+			continue
+		}
+		quota := strings.TrimPrefix(name, rateLimitRemainingPrefix)
+		if count, err := strconv.Atoi(strings.TrimSpace(values[0])); err == nil {
+			remaining[quota] = count
+			continue
+		}
+		// resource provider windows: name;count,name;count
+		for _, window := range strings.Split(values[0], ",") {
+			parts := strings.SplitN(strings.TrimSpace(window), ";", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if count, err := strconv.Atoi(parts[1]); err == nil {
+				remaining[parts[0]] = count
+			}
+		}
+	}
+	return remaining
+}
+
+// RetryAfter parses the Retry-After header, given either in seconds or as an HTTP date
+func RetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	val := header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(val); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(val); err == nil {
+		return date.Sub(now), true
+	}
+	return 0, false
+}
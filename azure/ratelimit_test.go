@@ -0,0 +1,132 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemainingQuota(t *testing.T) {
+	header := http.Header{}
+	header.Set("x-ms-ratelimit-remaining-subscription-reads", "11999")
+	header.Set("x-ms-ratelimit-remaining-resource", "Microsoft.Compute/HighCostGet3Min;139,Microsoft.Compute/HighCostGet30Min;699")
+	header.Set("x-ms-request-id", "1")
+
+	assert.Equal(t, map[string]int{
+		"subscription-reads":                 11999,
+		"Microsoft.Compute/HighCostGet3Min":  139,
+		"Microsoft.Compute/HighCostGet30Min": 699,
+	}, RemainingQuota(header))
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	var retryAfterTest = []struct {
+		value    string
+		expectOk bool
+		expected time.Duration
+	}{
+		{"17", true, 17 * time.Second},
+		{"Thu, 01 Aug 2019 12:01:00 GMT", true, time.Minute},
+		{"", false, 0},
+		{"soon", false, 0},
+	}
+
+	for _, tt := range retryAfterTest {
+		t.Run(tt.value, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			retryAfter, ok := RetryAfter(header, now)
+			assert.Equal(t, tt.expectOk, ok)
+			assert.Equal(t, tt.expected, retryAfter)
+		})
+	}
+}
+
+func TestRateLimiterBacksOff(t *testing.T) {
+	limiter := NewRateLimiter(10, 1, 5)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// throttled reads slow down and wait out Retry-After
+	throttled := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	throttled.Header.Set("Retry-After", "30")
+	limiter.Observe("sub", false, throttled)
+	assert.Equal(t, 5.0, limiter.Limit("sub", false))
+	assert.Equal(t, 1.0, limiter.Limit("sub", true))
+	assert.Equal(t, now.Add(30*time.Second), limiter.subscription("sub").retryAfter)
+
+	// low quota slows down further, but not below the minimum
+	low := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	low.Header.Set("x-ms-ratelimit-remaining-subscription-reads", "3")
+	for i := 0; i < 10; i++ {
+		limiter.Observe("SUB", false, low)
+	}
+	assert.Equal(t, 0.5, limiter.Limit("sub", false))
+
+	// plentiful quota speeds back up to the configured rate
+	ok := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	ok.Header.Set("x-ms-ratelimit-remaining-subscription-reads", "11000")
+	for i := 0; i < 20; i++ {
+		limiter.Observe("sub", false, ok)
+	}
+	assert.Equal(t, 10.0, limiter.Limit("sub", false))
+
+	// other subscriptions are limited separately
+	assert.Equal(t, 10.0, limiter.Limit("sub2", false))
+}
+
+func TestRateLimiterSendDecorator(t *testing.T) {
+	limiter := NewRateLimiter(10, 1, 5)
+	sent := 0
+	sender := autorest.DecorateSender(autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"60"}}}, nil
+	}), limiter.SendDecorator("sub"))
+
+	req, err := http.NewRequest(http.MethodGet, "https://management.azure.com", nil)
+	assert.NoError(t, err)
+	resp, err := sender.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// the next request waits for Retry-After, so it's cut off by the deadline without being sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = sender.Do(req.WithContext(ctx))
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	var validateTest = []struct {
+		name      string
+		readQPS   float64
+		writeQPS  float64
+		burst     int
+		expectErr bool
+	}{
+		{"defaults", 3, 0.3, 10, false},
+		{"zero read rate", 0, 0.3, 10, true},
+		{"negative write rate", 3, -1, 10, true},
+		{"zero burst", 3, 0.3, 0, true},
+		{"burst of 1", 3, 0.3, 1, false},
+	}
+
+	for _, tt := range validateTest {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRateLimits(tt.readQPS, tt.writeQPS, tt.burst)
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
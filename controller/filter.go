@@ -1,28 +1,41 @@
 package controller
 
 import (
-	"strings"
-	"time"
+	"reflect"
 
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
 )
 
+// filters the node and sync policy events of the reconcile loop
+var eventFilter = predicate.Funcs{
+	UpdateFunc:  updateFunc,
+	CreateFunc:  createFunc,
+	DeleteFunc:  deleteFunc,
+	GenericFunc: genericFunc,
+}
+
 func updateFunc(e event.UpdateEvent) bool {
-	node, ok := e.ObjectNew.(*corev1.Node)
-	if !ok {
-		return false
+	switch obj := e.ObjectNew.(type) {
+	case *corev1.Node:
+		return nodeChanged(e.ObjectOld.(*corev1.Node), obj)
+	case *v1alpha1.NodeLabelSyncPolicy:
+		// generation only changes with the spec, so status updates are ignored
+		return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
 	}
-	return timeToUpdate(node)
+	return false
 }
 
-// somehow there's a ton of create events
+// nodes are synced when created and on resync, so tags are applied within the sync period
 func createFunc(e event.CreateEvent) bool {
-	node, ok := e.Object.(*corev1.Node)
-	if !ok {
-		return false
+	switch e.Object.(type) {
+	case *corev1.Node, *v1alpha1.NodeLabelSyncPolicy:
+		return true
 	}
-	return timeToUpdate(node)
+	return false
 }
 
 // return true because vmss might need to be updated?
@@ -34,26 +47,12 @@ func genericFunc(e event.GenericEvent) bool {
 	return false
 }
 
-func timeToUpdate(node *corev1.Node) bool {
-	label, ok := node.Labels[lastUpdateLabel]
-	if !ok {
-		return true // let things through the first time
-	}
-	var period time.Duration
-	// if lastUpdate formatted incorrectly, do I let stuff through?
-	lastUpdate, err := time.Parse(time.RFC3339, strings.ReplaceAll(label, ".", ":"))
-	if err != nil {
-		return true // letting everything through if label formatted incorrectly
-	}
-	minSyncPeriod, ok := node.Labels[minSyncPeriodLabel]
-	if ok {
-		period, err = time.ParseDuration(minSyncPeriod)
-		if err != nil {
-			period = FiveMinutes
-		}
-	} else {
-		period = FiveMinutes
+// whether a node update needs a sync. Status updates, like heartbeats, are ignored. Reconcile delays syncs
+// of nodes synced within their minSyncPeriod.
+func nodeChanged(old, new *corev1.Node) bool {
+	if old.ResourceVersion == new.ResourceVersion {
+		return true // resync
 	}
-	syncPeriodStart := time.Now().Add(-period)
-	return lastUpdate.Before(syncPeriodStart)
+	return !reflect.DeepEqual(old.Labels, new.Labels) || !reflect.DeepEqual(old.Spec.Taints, new.Spec.Taints) ||
+		old.Spec.ProviderID != new.Spec.ProviderID
 }
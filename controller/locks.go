@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package controller
+
+import (
+	"sync"
+)
+
+// resourceLocks serializes syncs of the same VM or VMSS, so concurrent reconciles of nodes on it don't
+// read the same tags and write over each other's changes. The zero value is ready to use.
+type resourceLocks struct {
+	lock  sync.Mutex
+	locks map[string]*resourceLock
+}
+
+type resourceLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock the resource with the given key, returning the function that unlocks it
+func (l *resourceLocks) acquire(key string) func() {
+	l.lock.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*resourceLock{}
+	}
+	rl, ok := l.locks[key]
+	if !ok {
+		rl = &resourceLock{}
+		l.locks[key] = rl
+	}
+	rl.waiters++
+	l.lock.Unlock()
+
+	rl.Lock()
+	return func() {
+		rl.Unlock()
+
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		// remove the lock once nobody holds or waits for it, so locks of deleted resources aren't kept
+		if rl.waiters--; rl.waiters == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
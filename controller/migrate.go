@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/Azure/node-label-operator/labelsync"
+)
+
+// labels that earlier versions put on every node to rate limit syncs, which are now scheduled in memory
+var legacyLabels = []string{
+	"node-label-operator/last-update",
+	"node-label-operator/min-sync-period",
+}
+
+// manager runnable that removes the legacy labels from all nodes once the cache has synced. Failures are
+// logged and not retried, since the labels are harmless.
+func (r *ReconcileNodeLabel) removeLegacyLabels(stop <-chan struct{}) error {
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
+	if err := r.removeLegacyLabelsFromNodes(ctx); err != nil {
+		r.Log.Error(err, "failed to remove legacy labels from nodes")
+	}
+	<-stop
+	return nil
+}
+
+func (r *ReconcileNodeLabel) removeLegacyLabelsFromNodes(ctx context.Context) error {
+	var nodeList corev1.NodeList
+	if err := r.List(ctx, &nodeList); err != nil {
+		return err
+	}
+	removed := 0
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		patch, err := legacyLabelsPatch(node)
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			continue
+		}
+		if err := r.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
+			r.Log.Error(err, "failed to remove legacy labels", "node", node.Name)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		r.Log.V(0).Info("removed legacy labels from nodes", "nodes", removed)
+	}
+	return nil
+}
+
+// patch deleting the legacy labels of the node, nil if it has none
+func legacyLabelsPatch(node *corev1.Node) ([]byte, error) {
+	labels := map[string]*string{}
+	for _, label := range legacyLabels {
+		if _, ok := node.Labels[label]; ok {
+			labels[label] = nil
+		}
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labelsync.LabelPatchWithDelete(labels)
+}
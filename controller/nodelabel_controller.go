@@ -5,161 +5,426 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/prometheus/common/log"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/Azure/node-label-operator/api/v1alpha1"
 	"github.com/Azure/node-label-operator/azure"
 	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
 	"github.com/Azure/node-label-operator/labelsync"
+	"github.com/Azure/node-label-operator/labelsync/naming"
 	"github.com/Azure/node-label-operator/labelsync/options"
 )
 
 const (
-	lastUpdateLabel    string        = "node-label-operator/last-update"
-	minSyncPeriodLabel string        = "node-label-operator/min-sync-period"
-	FiveMinutes        time.Duration = time.Minute * 5
+	FiveMinutes time.Duration = time.Minute * 5
+	// attempts to write tags when they keep changing between reading and writing them
+	maxTagWriteAttempts int = 3
 )
 
+// ReconcileNodeLabel holds the clients and state used to sync nodes with the VM or VMSS they run on, see
+// ReconcileComputeResource
 type ReconcileNodeLabel struct {
 	client.Client
-	Log           logr.Logger
-	Scheme        *runtime.Scheme
-	Recorder      record.EventRecorder
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// MinSyncPeriod is the minimum time between syncs of a node whose options don't set one. It's only read
+	// by reconciles, the time of each node's last sync is kept in syncs.
 	MinSyncPeriod time.Duration
-	ctx           context.Context
-	lock          sync.Mutex
+	// MaxConcurrentReconciles is the number of reconciles run at once. Default is 1.
+	MaxConcurrentReconciles int
+	// ReconcileTimeout is the deadline of each reconcile, including its ARM requests. 0 for no deadline.
+	ReconcileTimeout time.Duration
+	// Clients creates the ARM clients used to read and write tags, and is shared by all reconciles
+	Clients azure.ClientFactory
+	// Cache holds the VMs and VMSSs read from ARM, so nodes on the same resource share reads. Optional.
+	Cache *azrsrc.Cache
+	// Health follows which subscriptions can be synced, and backs off from unhealthy ones. Optional.
+	Health *azure.HealthTracker
+	syncs  syncSchedule
+	// held while a VM or VMSS is synced, so only one reconcile at a time reads and writes its tags
+	resources resourceLocks
+	// canceled when the manager stops, see cancelOnStop
+	stopped context.Context
 }
 
-// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=nodes/status,verbs=get
-// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+// select the sync policy of the node, record it on the node, and return the node with the policy's options.
+// It's nil if the node shouldn't be synced, and requeue is true if that's because of an error.
+func (r *ReconcileNodeLabel) nodeConfigOptions(ctx context.Context, log logr.Logger, node *corev1.Node, provider *azure.Resource) (
+	n *nodeSync, requeue bool) {
 
-func (r *ReconcileNodeLabel) Reconcile(req reconcile.Request) (reconcile.Result, error) {
-	r.ctx = context.Background()
-	log := r.Log.WithValues("node-label-operator", req.NamespacedName)
+	policy, err := r.getPolicy(ctx, log, node, provider)
+	if err != nil {
+		log.Error(err, "failed to select sync policy")
+		return nil, true
+	}
+	if policy == nil {
+		log.V(1).Info("no sync policy matches node", "node", node.Name)
+		return nil, false
+	}
+	configOptions, err := r.getConfigOptions(ctx, log, policy)
+	if err != nil {
+		log.Error(err, "failed to load options from sync policy", "policy", policy.Name)
+		return nil, true
+	}
+	if err := r.recordPolicy(ctx, log, node, policy, configOptions); err != nil {
+		log.Error(err, "failed to record sync policy on node", "policy", policy.Name)
+		return nil, true
+	}
 
-	var configMap corev1.ConfigMap
-	optionsNamespacedName := options.ConfigMapNamespacedName() // assuming "node-label-operator" and "node-label-operator-system", is this okay
-	if err := r.Get(r.ctx, optionsNamespacedName, &configMap); err != nil {
-		log.V(1).Info("unable to fetch ConfigMap, instead using default configuration settings")
-		// create default options config map and requeue
-		configMap, err := options.NewDefaultConfig()
+	if _, err := time.ParseDuration(configOptions.MinSyncPeriod); err != nil {
+		log.Error(err, "failed to parse minSyncPeriod")
+		return nil, true
+	}
+
+	if configOptions.ResourceGroupFilter != options.DefaultResourceGroupFilter &&
+		provider.ResourceGroup != configOptions.ResourceGroupFilter {
+		log.V(1).Info("found node not in resource group filter", "resource group filter", configOptions.ResourceGroupFilter, "node", node.Name)
+		return nil, false
+	}
+	return &nodeSync{node: node, provider: *provider, policy: policy.Name, configOptions: configOptions}, false
+}
+
+// choose the sync policy for a node. If there are no policies yet, one is created from the
+// legacy options ConfigMap (or from default settings).
+func (r *ReconcileNodeLabel) getPolicy(ctx context.Context, log logr.Logger, node *corev1.Node, provider *azure.Resource) (*v1alpha1.NodeLabelSyncPolicy, error) {
+	var policyList v1alpha1.NodeLabelSyncPolicyList
+	if err := r.List(ctx, &policyList); err != nil {
+		return nil, err
+	}
+	if len(policyList.Items) == 0 {
+		newPolicy, err := r.newPolicy(ctx, log)
 		if err != nil {
-			log.Error(err, "failed to get new default options configmap")
-			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+			return nil, err
 		}
-		if err := r.Create(r.ctx, configMap); err != nil {
-			log.Error(err, "failed to create new default options configmap")
-			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		if err := r.Create(ctx, newPolicy); err != nil {
+			if !apierrors.IsAlreadyExists(err) {
+				return nil, err
+			}
+			// created by a concurrent reconcile
+			if err := r.Get(ctx, types.NamespacedName{Name: newPolicy.Name}, newPolicy); err != nil {
+				return nil, err
+			}
 		}
-		return ctrl.Result{RequeueAfter: time.Minute}, nil
+		policyList.Items = append(policyList.Items, *newPolicy)
 	}
-	configOptions, err := options.NewConfig(configMap)
-	if err != nil {
-		log.Error(err, "failed to load options from config file")
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+
+	policy, invalid := options.SelectPolicy(policyList.Items, node, provider.ResourceName)
+	for _, i := range invalid {
+		r.invalidPolicy(ctx, log, i.Policy, i.Err)
 	}
+	return policy, nil
+}
 
-	configMinSyncPeriod, err := time.ParseDuration(configOptions.MinSyncPeriod)
+// get options from the sync policy, and record whether the policy is valid in its status
+func (r *ReconcileNodeLabel) getConfigOptions(ctx context.Context, log logr.Logger, policy *v1alpha1.NodeLabelSyncPolicy) (*options.ConfigOptions, error) {
+	configOptions, err := options.NewConfigFromPolicy(policy)
 	if err != nil {
-		log.Error(err, "failed to parse minSyncPeriod")
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		r.invalidPolicy(ctx, log, policy, err)
+		return nil, err
 	}
-	if configMinSyncPeriod.Milliseconds() != r.MinSyncPeriod.Milliseconds() {
-		r.setMinSyncPeriod(configMinSyncPeriod)
+	if err := r.updatePolicyStatus(ctx, policy, corev1.ConditionTrue, "Accepted", ""); err != nil {
+		log.Error(err, "failed to update sync policy status")
 	}
+	return configOptions, nil
+}
 
-	var node corev1.Node
-	if err := r.Get(r.ctx, req.NamespacedName, &node); err != nil {
-		log.Error(err, "unable to fetch Node")
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+// record that the sync policy is invalid in an event and in its status
+func (r *ReconcileNodeLabel) invalidPolicy(ctx context.Context, log logr.Logger, policy *v1alpha1.NodeLabelSyncPolicy, err error) {
+	r.Recorder.Event(policy, "Warning", "InvalidSyncPolicy", err.Error())
+	if statusErr := r.updatePolicyStatus(ctx, policy, corev1.ConditionFalse, "InvalidSpec", err.Error()); statusErr != nil {
+		log.Error(statusErr, "failed to update sync policy status")
 	}
-	log.V(1).Info("provider info", "provider ID", node.Spec.ProviderID)
-	provider, err := azure.ParseProviderID(node.Spec.ProviderID)
+}
+
+// annotate the node with the policy and label prefixes applied to it if any changed,
+// deleting labels under the previous label prefix and platform labels under the previous platform label prefix
+func (r *ReconcileNodeLabel) recordPolicy(ctx context.Context, log logr.Logger, node *corev1.Node, policy *v1alpha1.NodeLabelSyncPolicy,
+	configOptions *options.ConfigOptions) error {
+
+	patch, err := policyPatch(log, node, policy, configOptions)
 	if err != nil {
-		log.Error(err, "invalid provider ID", "node", node.Name)
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		return err
 	}
-	if configOptions.ResourceGroupFilter != options.DefaultResourceGroupFilter &&
-		provider.ResourceGroup != configOptions.ResourceGroupFilter {
-		log.V(1).Info("found node not in resource group filter", "resource group filter", configOptions.ResourceGroupFilter, "node", node.Name)
-		return ctrl.Result{}, nil
+	if patch == nil {
+		return nil
 	}
+	return r.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, patch))
+}
 
-	switch provider.ResourceType {
-	case azrsrc.VMSS:
-		// Add VMSS tags to node
-		if err := r.reconcileVMSS(req.NamespacedName, &provider, &node, configOptions); err != nil {
-			log.Error(err, "failed to apply tags to nodes")
-			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
-		}
-	case azrsrc.VM:
-		// Add VM tags to node
-		if err := r.reconcileVMs(req.NamespacedName, &provider, &node, configOptions); err != nil {
-			log.Error(err, "failed to apply tags to nodes")
-			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
-		}
-	default:
-		log.V(1).Info("unrecognized resource type", "resource type", provider.ResourceType)
-	}
+// return patch recording the policy on the node, or nil if it is already recorded
+func policyPatch(log logr.Logger, node *corev1.Node, policy *v1alpha1.NodeLabelSyncPolicy,
+	configOptions *options.ConfigOptions) ([]byte, error) {
 
-	// update lastUpdate label on node
-	if err = r.updateMinSyncPeriodLabels(&node); err != nil {
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	oldPrefix, ok := node.Annotations[options.LabelPrefixAnnotation]
+	oldPlatformPrefix, platformOk := node.Annotations[options.PlatformLabelPrefixAnnotation]
+	if ok && oldPrefix == configOptions.LabelPrefix && platformOk && oldPlatformPrefix == configOptions.PlatformLabelPrefix &&
+		node.Annotations[options.PolicyAnnotation] == policy.Name {
+		return nil, nil
 	}
 
-	return ctrl.Result{}, nil
+	labels := map[string]*string{}
+	if ok && oldPrefix != configOptions.LabelPrefix && oldPrefix != "" {
+		labels = labelsync.DeleteLabelsWithPrefix(node, oldPrefix)
+		log.V(0).Info("label prefix changed, deleting labels with old prefix", "old prefix", oldPrefix,
+			"new prefix", configOptions.LabelPrefix, "deleted labels", len(labels))
+	}
+	if platformOk && oldPlatformPrefix != configOptions.PlatformLabelPrefix && oldPlatformPrefix != "" {
+		deleted := labelsync.DeletePlatformLabels(node, oldPlatformPrefix)
+		log.V(0).Info("platform label prefix changed, deleting platform labels with old prefix", "old prefix", oldPlatformPrefix,
+			"new prefix", configOptions.PlatformLabelPrefix, "deleted labels", len(deleted))
+		for labelName, val := range deleted {
+			labels[labelName] = val
+		}
+	}
+	annotations := map[string]*string{
+		options.PolicyAnnotation:              &policy.Name,
+		options.LabelPrefixAnnotation:         &configOptions.LabelPrefix,
+		options.PlatformLabelPrefixAnnotation: &configOptions.PlatformLabelPrefix,
+	}
+	return labelsync.MetadataPatch(labels, annotations)
 }
 
-// pass VMSS -> tags info and assign to nodes on VMs (unless node already has label)
-func (r *ReconcileNodeLabel) reconcileVMSS(namespacedName types.NamespacedName, provider *azure.Resource,
-	node *corev1.Node, configOptions *options.ConfigOptions) error {
+// migrate the options ConfigMap if there is one, otherwise use default settings
+func (r *ReconcileNodeLabel) newPolicy(ctx context.Context, log logr.Logger) (*v1alpha1.NodeLabelSyncPolicy, error) {
+	var configMap corev1.ConfigMap
+	if err := r.Get(ctx, options.ConfigMapNamespacedName(), &configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		log.V(1).Info("no sync policy or options ConfigMap found, creating default sync policy")
+		return options.NewDefaultPolicy(), nil
+	}
+	log.V(0).Info("migrating options ConfigMap to sync policy", "configmap", options.ConfigMapNamespacedName())
+	return options.NewPolicyFromConfigMap(configMap)
+}
 
-	log := r.Log.WithValues("node-label-operator", namespacedName)
+// only writes status when the generation or the Valid condition changes. The status is patched without the
+// policy's resourceVersion, since every reconcile checks the policy and most hold an older copy of it.
+func (r *ReconcileNodeLabel) updatePolicyStatus(ctx context.Context, policy *v1alpha1.NodeLabelSyncPolicy,
+	status corev1.ConditionStatus, reason, message string) error {
 
-	vmss, err := azrsrc.NewVMSS(r.ctx, provider.SubscriptionID, provider.ResourceGroup, provider.ResourceName)
+	for _, condition := range policy.Status.Conditions {
+		if condition.Type == v1alpha1.PolicyValid && condition.Status == status &&
+			condition.Message == message && policy.Status.ObservedGeneration == policy.Generation {
+			return nil
+		}
+	}
+	conditions := []v1alpha1.NodeLabelSyncPolicyCondition{{
+		Type:               v1alpha1.PolicyValid,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}}
+	// only the fields set here, so subscription statuses written concurrently are kept
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"observedGeneration": policy.Generation, "conditions": conditions},
+	})
 	if err != nil {
 		return err
 	}
+	return r.Status().Patch(ctx, policy, client.ConstantPatch(types.MergePatchType, patch))
+}
 
-	if configOptions.SyncDirection == options.TwoWay || configOptions.SyncDirection == options.ARMToNode {
-		// only update if there are changes to labels
-		patch, err := labelsync.TagsToNodes(namespacedName, *vmss, node, configOptions, log, r.Recorder)
+// record the health of the nodes' subscription and log err, returning whether syncing failed. Errors that
+// make the subscription unhealthy are only logged when its health changes, rather than for every node. Until
+// enough resources of the subscription are forbidden to make it unhealthy, ex. with a role assignment scoped to
+// other resource groups, a 403 is recorded on the nodes instead.
+func (r *ReconcileNodeLabel) syncFailed(ctx context.Context, log logr.Logger, provider *azure.Resource, nodes []nodeSync, err error) bool {
+	health, changed := r.Health.Observe(provider.SubscriptionID, provider.Key(), err)
+	if changed {
+		if health.Healthy {
+			log.V(0).Info("subscription is healthy", "subscription", provider.SubscriptionID)
+		} else {
+			log.Error(err, "subscription is unhealthy, backing off", "subscription", provider.SubscriptionID, "reason", health.Reason)
+		}
+		if statusErr := r.updateSubscriptionStatus(ctx, health); statusErr != nil {
+			log.Error(statusErr, "failed to update subscription status of sync policies")
+		}
+	}
+	if err == nil {
+		return false
+	}
+	if _, ok := azure.SubscriptionErrorReason(err); ok {
+		return true
+	}
+	if !health.Healthy && azure.IsForbidden(err) {
+		// forbidden throughout the subscription, see HealthTracker.Observe
+		return true
+	}
+	log.Error(err, "failed to apply tags to nodes")
+	if azure.IsForbidden(err) {
+		for _, n := range nodes {
+			r.Recorder.Event(n.node, "Warning", "ResourceForbidden",
+				fmt.Sprintf("Not allowed to sync tags of '%s': %v", provider.ResourceName, err))
+		}
+	}
+	return true
+}
+
+// record the health of a subscription in the status of every sync policy
+func (r *ReconcileNodeLabel) updateSubscriptionStatus(ctx context.Context, health azure.SubscriptionHealth) error {
+	var policyList v1alpha1.NodeLabelSyncPolicyList
+	if err := r.List(ctx, &policyList); err != nil {
+		return err
+	}
+	status := v1alpha1.SubscriptionStatus{
+		SubscriptionID:     health.SubscriptionID,
+		Healthy:            health.Healthy,
+		LastTransitionTime: metav1.NewTime(health.Since),
+		Reason:             health.Reason,
+		Message:            health.Message,
+	}
+	for i := range policyList.Items {
+		policy := &policyList.Items[i]
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			if !setSubscriptionStatus(&policy.Status, status) {
+				return nil
+			}
+			err := r.Status().Update(ctx, policy)
+			if apierrors.IsConflict(err) {
+				// read the policy again, keeping the statuses of other subscriptions written since it was listed
+				if getErr := r.Get(ctx, types.NamespacedName{Name: policy.Name}, policy); getErr != nil {
+					return getErr
+				}
+			}
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		if patch != nil { // gross looking
-			if err = r.Patch(r.ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
+	}
+	return nil
+}
+
+// add or replace the subscription's status, returning false if it's unchanged
+func setSubscriptionStatus(policyStatus *v1alpha1.NodeLabelSyncPolicyStatus, status v1alpha1.SubscriptionStatus) bool {
+	for i, existing := range policyStatus.Subscriptions {
+		if !strings.EqualFold(existing.SubscriptionID, status.SubscriptionID) {
+			continue
+		}
+		if existing.Healthy == status.Healthy && existing.Reason == status.Reason && existing.Message == status.Message {
+			return false
+		}
+		policyStatus.Subscriptions[i] = status
+		return true
+	}
+	policyStatus.Subscriptions = append(policyStatus.Subscriptions, status)
+	return true
+}
+
+// a node to sync, with the name and options of its sync policy
+type nodeSync struct {
+	node          *corev1.Node
+	provider      azure.Resource
+	policy        string
+	configOptions *options.ConfigOptions
+}
+
+func (n nodeSync) namespacedName() types.NamespacedName {
+	return types.NamespacedName{Name: n.node.Name}
+}
+
+// a VM or VMSS
+type platformComputeResource interface {
+	azrsrc.ComputeResource
+	azrsrc.PlatformResource
+}
+
+// read the VM or VMSS from ARM, or the cache
+func (r *ReconcileNodeLabel) loadComputeResource(ctx context.Context, provider *azure.Resource) (platformComputeResource, error) {
+	switch {
+	case strings.EqualFold(provider.ResourceType, azrsrc.VMSS):
+		vmss, err := azrsrc.NewVMSS(ctx, r.Clients, r.Cache, provider.SubscriptionID, provider.ResourceGroup, provider.ResourceName)
+		if err != nil {
+			return nil, err
+		}
+		return *vmss, nil
+	case strings.EqualFold(provider.ResourceType, azrsrc.VM):
+		vm, err := azrsrc.NewVM(ctx, r.Clients, r.Cache, provider.SubscriptionID, provider.ResourceGroup, provider.ResourceName)
+		if err != nil {
+			return nil, err
+		}
+		return *vm, nil
+	}
+	return nil, fmt.Errorf("unrecognized resource type %s", provider.ResourceType)
+}
+
+// sync nodes running on the same VM or VMSS, which is read once for all of them. Tags are applied to each
+// node, and the combined labels of the nodes are written to the resource in one update per sync policy.
+// siblings are the other nodes on the resource, whose labels are combined with the synced nodes' labels.
+// Syncs of the same resource wait for each other.
+func (r *ReconcileNodeLabel) syncComputeResource(ctx context.Context, provider *azure.Resource, nodes []nodeSync, siblings []corev1.Node) error {
+	unlock := r.resources.acquire(provider.Key())
+	defer unlock()
+
+	computeResource, err := r.loadComputeResource(ctx, provider)
+	if err != nil {
+		return err
+	}
+
+	var writers []nodeSync
+	for _, n := range nodes {
+		syncDirection := n.configOptions.SyncDirection
+		if syncDirection == options.TwoWay || syncDirection == options.ARMToNode {
+			if err := r.syncTagsToNode(ctx, computeResource, n); err != nil {
 				return err
 			}
 		}
+		if syncDirection == options.TwoWay || syncDirection == options.NodeToARM {
+			writers = append(writers, n)
+		} else {
+			siblings = append(siblings, *n.node)
+		}
 	}
 
-	// assign all labels on Node to VMSS, if not already there
-	if configOptions.SyncDirection == options.TwoWay || configOptions.SyncDirection == options.NodeToARM {
-		// only update if there are changes to labels
-		tags, err := labelsync.LabelsToAzureResource(namespacedName, *vmss, node, configOptions, log, r.Recorder)
-		if err != nil {
-			return err
+	// assign all labels on nodes to the VM or VMSS, if not already there. Nodes of each sync policy are
+	// written with its options, and the nodes of other policies are their siblings.
+	reload := func() (azrsrc.ComputeResource, error) {
+		return r.loadComputeResource(ctx, provider)
+	}
+	groups := groupByPolicy(writers)
+	for i, group := range groups {
+		var target azrsrc.ComputeResource = computeResource
+		if i > 0 {
+			// read again, since the previous group may have written the tags
+			if target, err = reload(); err != nil {
+				return err
+			}
 		}
-		if tags != nil {
-			for key, val := range tags {
-				vmss.SetTag(key, val)
+		groupSiblings := siblings
+		for _, other := range groups {
+			if other[0].policy == group[0].policy {
+				continue
+			}
+			for _, n := range other {
+				groupSiblings = append(groupSiblings, *n.node)
 			}
-			if err = vmss.Update(r.ctx); err != nil {
+		}
+		if err := r.syncLabelsToAzureResource(ctx, target, reload, group, groupSiblings); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range nodes {
+		if n.configOptions.PlatformLabelPrefix != "" {
+			if err := r.syncPlatformLabels(ctx, n.namespacedName(), computeResource, n.node, n.configOptions); err != nil {
 				return err
 			}
 		}
@@ -168,79 +433,237 @@ func (r *ReconcileNodeLabel) reconcileVMSS(namespacedName types.NamespacedName,
 	return nil
 }
 
-func (r *ReconcileNodeLabel) reconcileVMs(namespacedName types.NamespacedName, provider *azure.Resource,
-	node *corev1.Node, configOptions *options.ConfigOptions) error {
+// group nodes by their sync policy, in the order the policies are first seen
+func groupByPolicy(nodes []nodeSync) [][]nodeSync {
+	var groups [][]nodeSync
+	index := map[string]int{}
+	for _, n := range nodes {
+		i, ok := index[n.policy]
+		if !ok {
+			i = len(groups)
+			index[n.policy] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], n)
+	}
+	return groups
+}
 
-	log := r.Log.WithValues("node-label-operator", namespacedName)
+// apply tags of the VM or VMSS, and of the VMSS instance the node runs on, to the node's labels and taints
+func (r *ReconcileNodeLabel) syncTagsToNode(ctx context.Context, computeResource azrsrc.ComputeResource, n nodeSync) error {
+	log := r.Log.WithValues("node-label-operator", n.namespacedName())
+
+	tagSource := computeResource
+	if strings.EqualFold(n.provider.ResourceType, azrsrc.VMSS) && n.configOptions.InstanceTags != options.IgnoreInstanceTags &&
+		n.provider.InstanceID != "" {
+		instance, err := azrsrc.NewVMSSVM(ctx, r.Clients, r.Cache, n.provider.SubscriptionID, n.provider.ResourceGroup,
+			n.provider.ResourceName, n.provider.InstanceID)
+		if err != nil {
+			return err
+		}
+		tagSource = azrsrc.NewScaleSetInstance(computeResource, *instance, n.configOptions.InstanceTags == options.InstancePrecedence)
+	}
 
-	vm, err := azrsrc.NewVM(r.ctx, provider.SubscriptionID, provider.ResourceGroup, provider.ResourceName)
+	// only update if there are changes to labels
+	patch, err := labelsync.TagsToNodes(n.namespacedName(), tagSource, n.node, n.configOptions, log, r.Recorder)
 	if err != nil {
 		return err
 	}
-
-	if configOptions.SyncDirection == options.TwoWay || configOptions.SyncDirection == options.ARMToNode {
-		patch, err := labelsync.TagsToNodes(namespacedName, *vm, node, configOptions, log, r.Recorder)
-		if err != nil {
+	if patch != nil {
+		if err = r.Patch(ctx, n.node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
 			return err
 		}
-		if patch != nil {
-			if err = r.Patch(r.ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
-				return err
+	}
+	return r.syncTaints(ctx, n.namespacedName(), tagSource, n.node, n.configOptions)
+}
+
+// apply the combined labels of the nodes on the Azure resource, delete tags for labels removed from the
+// nodes being synced, and record the tags they own. The nodes share a sync policy, whose options are taken
+// from the first node. If the resource's tags changed since they were read, they're read again with reload
+// and the changes recomputed, up to maxTagWriteAttempts times.
+func (r *ReconcileNodeLabel) syncLabelsToAzureResource(ctx context.Context, computeResource azrsrc.ComputeResource,
+	reload func() (azrsrc.ComputeResource, error), nodes []nodeSync, siblings []corev1.Node) error {
+
+	node, configOptions := nodes[0].node, nodes[0].configOptions
+	log := r.Log.WithValues("node-label-operator", nodes[0].namespacedName())
+
+	owners := make([]*corev1.Node, 0, len(nodes))
+	for _, n := range nodes {
+		owners = append(owners, n.node)
+		if n.node != node {
+			siblings = append(siblings, *n.node)
+		}
+	}
+	desired := labelsync.AggregateLabels(node, siblings, configOptions, log, r.Recorder)
+	// labels synced from tags are taken from each node being synced rather than aggregated, see AggregateLabels
+	for _, owner := range owners[1:] {
+		for labelName, labelVal := range owner.Labels {
+			if _, ok := desired.Labels[labelName]; !ok && naming.HasLabelPrefix(labelName, configOptions.LabelPrefix) {
+				desired.Labels[labelName] = labelVal
 			}
 		}
 	}
 
-	if configOptions.SyncDirection == options.TwoWay || configOptions.SyncDirection == options.NodeToARM {
-		tags, err := labelsync.LabelsToAzureResource(namespacedName, *vm, node, configOptions, log, r.Recorder)
+	var changes map[string]*string
+	var err error
+	for attempt := 1; ; attempt++ {
+		// only update if there are changes to labels
+		changes, err = tagChanges(nodes[0].namespacedName(), computeResource, owners, desired, configOptions, log, r.Recorder)
 		if err != nil {
 			return err
 		}
-		if tags != nil {
-			for key, val := range tags {
-				vm.SetTag(key, val)
-			}
-			if err = vm.Update(r.ctx); err != nil {
-				return err
+		if len(changes) == 0 {
+			break
+		}
+		for key, val := range changes {
+			if val == nil {
+				computeResource.DeleteTag(key)
+			} else {
+				computeResource.SetTag(key, val)
 			}
 		}
+		err = computeResource.Update(ctx)
+		if err == nil {
+			break
+		}
+		if !azure.IsPreconditionFailed(err) {
+			return err
+		}
+		if attempt == maxTagWriteAttempts {
+			r.Recorder.Event(node, "Warning", "TagWriteConflict",
+				fmt.Sprintf("Gave up writing tags to '%s' after %d attempts, its tags kept changing.", computeResource.Name(), attempt))
+			return err
+		}
+		log.V(0).Info("tags changed since they were read, retrying", "resource", computeResource.Name(), "attempt", attempt)
+		if computeResource, err = reload(); err != nil {
+			return err
+		}
 	}
 
+	for _, owner := range owners {
+		if err := r.recordOwnedTags(ctx, owner, labelsync.UpdateOwnedTags(computeResource, owner, changes, configOptions)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (r *ReconcileNodeLabel) updateMinSyncPeriodLabels(node *corev1.Node) error {
-	r.lastUpdateLabel(node)
-	patch, err := labelsync.LabelPatch(node.Labels)
+// return the tags to set for the desired labels and the tags the owners no longer label, with nil values
+func tagChanges(namespacedName types.NamespacedName, computeResource azrsrc.ComputeResource, owners []*corev1.Node, desired *corev1.Node,
+	configOptions *options.ConfigOptions, log logr.Logger, recorder record.EventRecorder) (map[string]*string, error) {
+
+	tags, err := labelsync.LabelsToAzureResource(namespacedName, computeResource, desired, configOptions, log, recorder)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]*string{}
+	for key, val := range tags {
+		changes[key] = val
+	}
+	for _, owner := range owners {
+		for key := range labelsync.StaleTags(computeResource, owner, []corev1.Node{*desired}, configOptions, log) {
+			log.V(1).Info("deleting tag for removed node label", "tag name", key, "node", owner.Name)
+			changes[key] = nil
+		}
+	}
+	return changes, nil
+}
+
+// apply the policy's taint mappings to the node
+func (r *ReconcileNodeLabel) syncTaints(ctx context.Context, namespacedName types.NamespacedName, computeResource azrsrc.ComputeResource,
+	node *corev1.Node, configOptions *options.ConfigOptions) error {
+
+	log := r.Log.WithValues("node-label-operator", namespacedName)
+
+	patch, err := labelsync.TagsToTaints(computeResource, node, configOptions, log)
 	if err != nil {
 		return err
 	}
-	if err = r.Patch(r.ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
-		log.Error(err, "failed to patch lastUpdate label")
+	if patch != nil {
+		if err = r.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply the Azure platform metadata of the resource as labels with the platform label prefix
+func (r *ReconcileNodeLabel) syncPlatformLabels(ctx context.Context, namespacedName types.NamespacedName, platformResource azrsrc.PlatformResource,
+	node *corev1.Node, configOptions *options.ConfigOptions) error {
+
+	log := r.Log.WithValues("node-label-operator", namespacedName)
+
+	patch, err := labelsync.PlatformLabels(platformResource.PlatformMetadata(), node, configOptions, log)
+	if err != nil {
 		return err
 	}
+	if patch != nil {
+		if err = r.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// update the lastUpdate label on node, or create if not there
-func (r *ReconcileNodeLabel) lastUpdateLabel(node *corev1.Node) {
-	node.Labels[lastUpdateLabel] = strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", ".")
-	node.Labels[minSyncPeriodLabel] = r.MinSyncPeriod.String()
+// patch the owned tags annotation on the node if it changed
+func (r *ReconcileNodeLabel) recordOwnedTags(ctx context.Context, node *corev1.Node, owned map[string]bool) error {
+	val, err := labelsync.OwnedTagsAnnotationValue(owned)
+	if err != nil {
+		return err
+	}
+	if node.Annotations[labelsync.OwnedTagsAnnotation] == val {
+		return nil
+	}
+	patch, err := labelsync.MetadataPatch(nil, map[string]*string{labelsync.OwnedTagsAnnotation: &val})
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, patch))
+}
+
+// list the nodes running on the VM or VMSS with the key, using the index of nodes by resource
+func (r *ReconcileNodeLabel) nodesWithResourceKey(ctx context.Context, key string) ([]corev1.Node, error) {
+	var nodeList corev1.NodeList
+	if err := r.List(ctx, &nodeList, client.MatchingField(resourceKeyField, key)); err != nil {
+		return nil, err
+	}
+	// filter again, since not every client supports field selectors
+	var nodes []corev1.Node
+	for _, node := range nodeList.Items {
+		if keys := nodeResourceKeys(&node); len(keys) == 1 && keys[0] == key {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
 }
 
-func (r *ReconcileNodeLabel) setMinSyncPeriod(duration time.Duration) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	r.MinSyncPeriod = duration
+// the minimum time between syncs of a node with the options
+func (r *ReconcileNodeLabel) minSyncPeriod(configOptions *options.ConfigOptions) time.Duration {
+	if period, err := time.ParseDuration(configOptions.MinSyncPeriod); err == nil {
+		return period
+	}
+	return r.MinSyncPeriod
+}
+
+// context of one reconcile, canceled when the manager stops or after ReconcileTimeout
+func (r *ReconcileNodeLabel) reconcileContext() (context.Context, context.CancelFunc) {
+	ctx := r.stopped
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.ReconcileTimeout > 0 {
+		return context.WithTimeout(ctx, r.ReconcileTimeout)
+	}
+	return context.WithCancel(ctx)
 }
 
-func (r *ReconcileNodeLabel) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Node{}).
-		WithEventFilter(predicate.Funcs{
-			UpdateFunc:  updateFunc,
-			CreateFunc:  createFunc,
-			DeleteFunc:  deleteFunc,
-			GenericFunc: genericFunc,
-		}).
-		Complete(r)
+// cancel running reconciles, and their ARM requests, when the manager stops
+func (r *ReconcileNodeLabel) cancelOnStop(mgr ctrl.Manager) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopped = ctx
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	}))
 }
@@ -5,93 +5,575 @@ package controller
 
 import (
 	"context"
-	"strings"
+	"encoding/json"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/azure"
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
 )
 
-func TestLastUpdateLabel(t *testing.T) {
-	var lastUpdateLabelTest = []struct {
-		name          string
-		minSyncPeriod time.Duration
-		expected      string
+func TestSyncSchedule(t *testing.T) {
+	now := time.Now()
+	schedule := syncSchedule{now: func() time.Time { return now }}
+	assert.Equal(t, time.Duration(0), schedule.wait("node1"))
+
+	schedule.synced("node1", FiveMinutes)
+	schedule.synced("node2", time.Minute)
+	assert.Equal(t, FiveMinutes, schedule.wait("node1"))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 3*time.Minute, schedule.wait("node1"))
+	assert.Equal(t, time.Duration(0), schedule.wait("node2"))
+
+	schedule.synced("node1", FiveMinutes)
+	schedule.reset()
+	assert.Equal(t, time.Duration(0), schedule.wait("node1"))
+
+	// nodes no longer listed on their resource are forgotten
+	schedule.synced("node1", FiveMinutes)
+	schedule.synced("node2", FiveMinutes)
+	schedule.listed("vmss1", []string{"node1", "node2"})
+	schedule.listed("vmss1", []string{"node1"})
+	assert.Equal(t, FiveMinutes, schedule.wait("node1"))
+	assert.Equal(t, time.Duration(0), schedule.wait("node2"))
+	schedule.listed("vmss1", nil)
+	assert.Equal(t, time.Duration(0), schedule.wait("node1"))
+	assert.Empty(t, schedule.resources)
+}
+
+func TestResourceLocks(t *testing.T) {
+	var locks resourceLocks
+	unlock := locks.acquire("vmss1")
+
+	// other resources aren't blocked
+	locks.acquire("vmss2")()
+
+	acquired := make(chan struct{})
+	go func() {
+		locks.acquire("vmss1")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a held lock")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+	assert.Empty(t, locks.locks)
+}
+
+func TestReconcileContext(t *testing.T) {
+	reconciler := NewFakeNodeLabelReconciler()
+	ctx, cancel := reconciler.reconcileContext()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+	cancel()
+
+	// reconciles are canceled when the manager stops
+	stopped, stop := context.WithCancel(context.Background())
+	reconciler.stopped = stopped
+	reconciler.ReconcileTimeout = time.Minute
+	ctx, cancel = reconciler.reconcileContext()
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= time.Minute)
+	assert.NoError(t, ctx.Err())
+	stop()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestPolicyEventFilter(t *testing.T) {
+	oldPolicy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{})
+	oldPolicy.Generation = 1
+	statusUpdate := oldPolicy.DeepCopy()
+	statusUpdate.Status.ObservedGeneration = 1
+	specUpdate := oldPolicy.DeepCopy()
+	specUpdate.Spec.SyncDirection = "two-way"
+	specUpdate.Generation = 2
+
+	assert.True(t, createFunc(event.CreateEvent{Meta: oldPolicy, Object: oldPolicy}))
+	assert.False(t, updateFunc(event.UpdateEvent{MetaOld: oldPolicy, ObjectOld: oldPolicy, MetaNew: statusUpdate, ObjectNew: statusUpdate}))
+	assert.True(t, updateFunc(event.UpdateEvent{MetaOld: oldPolicy, ObjectOld: oldPolicy, MetaNew: specUpdate, ObjectNew: specUpdate}))
+}
+
+func TestNodeEventFilter(t *testing.T) {
+	node := NewFakeNode("node1", map[string]string{"env": "test"})
+	node.ResourceVersion = "1"
+	heartbeat := node.DeepCopy()
+	heartbeat.ResourceVersion = "2"
+	heartbeat.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	relabeled := node.DeepCopy()
+	relabeled.ResourceVersion = "2"
+	relabeled.Labels["env"] = "prod"
+	tainted := node.DeepCopy()
+	tainted.ResourceVersion = "2"
+	tainted.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+
+	var eventFilterTest = []struct {
+		name     string
+		new      *corev1.Node
+		expected bool
+	}{
+		{"resync", node, true},
+		{"status update", heartbeat, false},
+		{"label update", relabeled, true},
+		{"taint update", tainted, true},
+	}
+
+	for _, tt := range eventFilterTest {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, updateFunc(event.UpdateEvent{MetaOld: node, ObjectOld: node, MetaNew: tt.new, ObjectNew: tt.new}))
+		})
+	}
+	assert.True(t, createFunc(event.CreateEvent{Meta: node, Object: node}))
+}
+
+func TestLegacyLabelsPatch(t *testing.T) {
+	var legacyLabelsTest = []struct {
+		name     string
+		labels   map[string]string
+		expected string
 	}{
+		{"no legacy labels", map[string]string{"env": "test"}, ""},
 		{
-			"node1",
-			FiveMinutes,
-			FiveMinutes.String(),
+			"legacy labels",
+			map[string]string{
+				"env":                                 "test",
+				"node-label-operator/last-update":     "2019-09-23T20.01.43Z",
+				"node-label-operator/min-sync-period": "5m0s",
+			},
+			`{"metadata":{"labels":{"node-label-operator/last-update":null,"node-label-operator/min-sync-period":null}}}`,
 		},
+	}
+
+	for _, tt := range legacyLabelsTest {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := legacyLabelsPatch(NewFakeNode("node1", tt.labels))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(patch))
+		})
+	}
+
+	// nodes without legacy labels aren't patched
+	reconciler := NewFakeNodeLabelReconciler(NewFakeNode("node1", map[string]string{"env": "test"}))
+	assert.NoError(t, reconciler.removeLegacyLabelsFromNodes(context.Background()))
+}
+
+func TestGetPolicy(t *testing.T) {
+	var getPolicyTest = []struct {
+		name                  string
+		existing              []runtime.Object
+		nodeLabels            map[string]string
+		expectedPolicy        string
+		expectedSyncDirection options.SyncDirection
+		expectedMigrated      bool
+	}{
 		{
-			"node2",
-			time.Minute,
-			time.Minute.String(),
+			"no policy or configmap",
+			[]runtime.Object{},
+			map[string]string{},
+			options.DefaultPolicyName,
+			options.ARMToNode,
+			false,
+		},
+		{
+			"migrate configmap",
+			[]runtime.Object{NewFakeConfigMap(map[string]string{"syncDirection": "two-way"})},
+			map[string]string{},
+			options.DefaultPolicyName,
+			options.TwoWay,
+			true,
+		},
+		{
+			"existing policy ignores configmap",
+			[]runtime.Object{
+				NewFakeConfigMap(map[string]string{"syncDirection": "two-way"}),
+				NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{SyncDirection: "node-to-arm"}),
+			},
+			map[string]string{},
+			options.DefaultPolicyName,
+			options.NodeToARM,
+			false,
+		},
+		{
+			"selected by node label",
+			[]runtime.Object{
+				NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{}),
+				NewFakePolicy("gpu", v1alpha1.NodeLabelSyncPolicySpec{
+					NodeSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"accelerator": "nvidia"}},
+					Priority:       10,
+					ConflictPolicy: "node-precedence",
+				}),
+			},
+			map[string]string{"accelerator": "nvidia"},
+			"gpu",
+			options.ARMToNode,
+			false,
 		},
 	}
 
-	for _, tt := range lastUpdateLabelTest {
+	for _, tt := range getPolicyTest {
 		t.Run(tt.name, func(t *testing.T) {
-			reconciler := NewFakeNodeLabelReconciler()
-			reconciler.MinSyncPeriod = tt.minSyncPeriod
-			node := NewFakeNode(tt.name, map[string]string{})
-			reconciler.lastUpdateLabel(node)
-			label, ok := node.Labels[minSyncPeriodLabel]
-			assert.True(t, ok)
-			assert.Equal(t, label, tt.expected)
+			reconciler := NewFakeNodeLabelReconciler(tt.existing...)
+			node := NewFakeNode(tt.name, tt.nodeLabels)
+			policy, err := reconciler.getPolicy(context.Background(), reconciler.Log, node, &azure.Resource{ResourceName: "vmss"})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPolicy, policy.Name)
+			configOptions, err := reconciler.getConfigOptions(context.Background(), reconciler.Log, policy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSyncDirection, configOptions.SyncDirection)
 
+			var saved v1alpha1.NodeLabelSyncPolicy
+			assert.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: tt.expectedPolicy}, &saved))
+			_, migrated := saved.Annotations[options.MigratedFromAnnotation]
+			assert.Equal(t, tt.expectedMigrated, migrated)
+			assert.Equal(t, 1, len(saved.Status.Conditions))
+			assert.Equal(t, corev1.ConditionTrue, saved.Status.Conditions[0].Status)
 		})
 	}
 }
 
-func TestTimeToUpdate(t *testing.T) {
-	var timeToUpdateTest = []struct {
-		name     string
-		labels   map[string]string
-		expected bool
+func TestGetPolicySkipsInvalidPolicy(t *testing.T) {
+	reconciler := NewFakeNodeLabelReconciler(
+		NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{}),
+		NewFakePolicy("bad", v1alpha1.NodeLabelSyncPolicySpec{ResourceNamePattern: "vm[ss", Priority: 10}),
+	)
+	policy, err := reconciler.getPolicy(context.Background(), reconciler.Log, NewFakeNode("node1", map[string]string{}),
+		&azure.Resource{ResourceName: "vmss"})
+	assert.NoError(t, err)
+	assert.Equal(t, options.DefaultPolicyName, policy.Name)
+
+	var bad v1alpha1.NodeLabelSyncPolicy
+	assert.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: "bad"}, &bad))
+	if assert.Equal(t, 1, len(bad.Status.Conditions)) {
+		assert.Equal(t, corev1.ConditionFalse, bad.Status.Conditions[0].Status)
+		assert.Equal(t, "InvalidSpec", bad.Status.Conditions[0].Reason)
+	}
+}
+
+func TestGetConfigOptionsInvalidPolicy(t *testing.T) {
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{MinSyncPeriod: "soon"})
+	reconciler := NewFakeNodeLabelReconciler(policy)
+	_, err := reconciler.getConfigOptions(context.Background(), reconciler.Log, policy)
+	assert.Error(t, err)
+
+	var saved v1alpha1.NodeLabelSyncPolicy
+	assert.NoError(t, reconciler.Get(context.Background(), options.PolicyNamespacedName(), &saved))
+	assert.Equal(t, 1, len(saved.Status.Conditions))
+	assert.Equal(t, corev1.ConditionFalse, saved.Status.Conditions[0].Status)
+}
+
+func TestUpdatePolicyStatusStaleCopy(t *testing.T) {
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{})
+	stale := policy.DeepCopy()
+	policy.Status.Subscriptions = []v1alpha1.SubscriptionStatus{{SubscriptionID: "sub", Healthy: true}}
+	reconciler := NewFakeNodeLabelReconciler(policy)
+
+	// a reconcile holding a copy of the policy from before the subscription status was written
+	assert.NoError(t, reconciler.updatePolicyStatus(context.Background(), stale, corev1.ConditionTrue, "Accepted", ""))
+
+	var saved v1alpha1.NodeLabelSyncPolicy
+	assert.NoError(t, reconciler.Get(context.Background(), options.PolicyNamespacedName(), &saved))
+	assert.Equal(t, 1, len(saved.Status.Conditions))
+	assert.Equal(t, corev1.ConditionTrue, saved.Status.Conditions[0].Status)
+	assert.Equal(t, policy.Status.Subscriptions, saved.Status.Subscriptions)
+}
+
+func TestRecordPolicy(t *testing.T) {
+	node := NewFakeNode("node1", map[string]string{})
+	reconciler := NewFakeNodeLabelReconciler(node.DeepCopy())
+	policy := NewFakePolicy("gpu", v1alpha1.NodeLabelSyncPolicySpec{})
+	configOptions := options.DefaultConfigOptions()
+	assert.NoError(t, reconciler.recordPolicy(context.Background(), reconciler.Log, node, policy, &configOptions))
+
+	var saved corev1.Node
+	assert.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: "node1"}, &saved))
+	assert.Equal(t, "gpu", saved.Annotations[options.PolicyAnnotation])
+	assert.Equal(t, options.DefaultLabelPrefix, saved.Annotations[options.LabelPrefixAnnotation])
+}
+
+func TestPolicyPatch(t *testing.T) {
+	var policyPatchTest = []struct {
+		name                string
+		labels              map[string]string
+		annotations         map[string]string
+		labelPrefix         string
+		platformLabelPrefix string
+		expectPatch         bool
+		expectedPatchLabels map[string]interface{}
 	}{
 		{
-			"node1",
+			"first sync",
+			map[string]string{"azure.tags/env": "test", "old.tags/env": "test"},
 			map[string]string{},
+			"azure.tags",
+			"",
 			true,
+			nil,
+		},
+		{
+			"unchanged",
+			map[string]string{"azure.tags/env": "test"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "azure.tags",
+				options.PlatformLabelPrefixAnnotation: "azure.platform"},
+			"azure.tags",
+			"azure.platform",
+			false,
+			nil,
 		},
 		{
-			"node2",
-			map[string]string{"node-label-operator/last-update": "2019-09-23T20.01.43Z", "node-label-operator/min-sync-period": "1m"},
+			"label prefix changed",
+			map[string]string{"azure.tags/env": "test", "old.tags/env": "test", "old.tags/v": "1", "kubernetes.io/os": "linux"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "old.tags",
+				options.PlatformLabelPrefixAnnotation: ""},
+			"azure.tags",
+			"",
 			true,
+			map[string]interface{}{"old.tags/env": nil, "old.tags/v": nil},
 		},
 		{
-			"node3",
-			map[string]string{"node-label-operator/last-update": strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", "."), "node-label-operator/min-sync-period": "100h"},
-			false,
+			"empty old label prefix",
+			map[string]string{"env": "test"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "",
+				options.PlatformLabelPrefixAnnotation: ""},
+			"azure.tags",
+			"",
+			true,
+			nil,
+		},
+		{
+			"platform label prefix not recorded yet",
+			map[string]string{"azure.platform/size": "Standard_DS2_v2"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "azure.tags"},
+			"azure.tags",
+			"",
+			true,
+			nil,
+		},
+		{
+			"platform label prefix changed",
+			map[string]string{"old.platform/size": "Standard_DS2_v2", "old.platform/image": "ubuntu", "old.platform/team": "a"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "azure.tags",
+				options.PlatformLabelPrefixAnnotation: "old.platform"},
+			"azure.tags",
+			"azure.platform",
+			true,
+			map[string]interface{}{"old.platform/size": nil, "old.platform/image": nil},
+		},
+		{
+			"platform labels disabled",
+			map[string]string{"azure.tags/env": "test", "azure.platform/size": "Standard_DS2_v2"},
+			map[string]string{options.PolicyAnnotation: "gpu", options.LabelPrefixAnnotation: "azure.tags",
+				options.PlatformLabelPrefixAnnotation: "azure.platform"},
+			"azure.tags",
+			"",
+			true,
+			map[string]interface{}{"azure.platform/size": nil},
 		},
 	}
 
-	for _, tt := range timeToUpdateTest {
+	for _, tt := range policyPatchTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node1", tt.labels)
+			node.Annotations = tt.annotations
+			policy := NewFakePolicy("gpu", v1alpha1.NodeLabelSyncPolicySpec{})
+			configOptions := options.DefaultConfigOptions()
+			configOptions.LabelPrefix = tt.labelPrefix
+			configOptions.PlatformLabelPrefix = tt.platformLabelPrefix
+			patch, err := policyPatch(ctrl.Log.WithName("test"), node, policy, &configOptions)
+			assert.NoError(t, err)
+			if !tt.expectPatch {
+				assert.Nil(t, patch)
+				return
+			}
+
+			spec := map[string]map[string]map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, tt.expectedPatchLabels, spec["metadata"]["labels"])
+			assert.Equal(t, "gpu", spec["metadata"]["annotations"][options.PolicyAnnotation])
+			assert.Equal(t, tt.labelPrefix, spec["metadata"]["annotations"][options.LabelPrefixAnnotation])
+			assert.Equal(t, tt.platformLabelPrefix, spec["metadata"]["annotations"][options.PlatformLabelPrefixAnnotation])
+		})
+	}
+}
+
+// compute resource whose writes fail with 412 Precondition Failed until conflicts runs out
+type conflictingComputeResource struct {
+	*azrsrc.FakeComputeResource
+	conflicts *int
+}
+
+func (c conflictingComputeResource) Update(ctx context.Context) error {
+	if *c.conflicts > 0 {
+		*c.conflicts--
+		return autorest.DetailedError{StatusCode: http.StatusPreconditionFailed}
+	}
+	return nil
+}
+
+func TestSyncLabelsToAzureResourceRetriesConflicts(t *testing.T) {
+	var conflictTest = []struct {
+		name          string
+		conflicts     int
+		expectSuccess bool
+		expectedReads int
+	}{
+		{"no conflict", 0, true, 0},
+		{"conflict resolved", 2, true, 2},
+		{"conflict not resolved", maxTagWriteAttempts, false, maxTagWriteAttempts - 1},
+	}
+
+	for _, tt := range conflictTest {
 		t.Run(tt.name, func(t *testing.T) {
-			node := NewFakeNode(tt.name, tt.labels)
+			node := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+			node.Spec.ProviderID = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+			reconciler := NewFakeNodeLabelReconciler(node)
+			recorder := record.NewFakeRecorder(10)
+			reconciler.Recorder = recorder
+			provider, err := azure.ParseProviderID(node.Spec.ProviderID)
+			assert.NoError(t, err)
+			configOptions := options.DefaultConfigOptions()
+			configOptions.SyncDirection = options.TwoWay
 
-			actual := timeToUpdate(node)
-			assert.Equal(t, tt.expected, actual)
+			conflicts := tt.conflicts
+			reads := 0
+			var latest conflictingComputeResource
+			reload := func() (azrsrc.ComputeResource, error) {
+				reads++
+				// someone else added a tag in the meantime
+				latest = conflictingComputeResource{azrsrc.NewFakeComputeResource(map[string]*string{"env": to.StringPtr("test")}), &conflicts}
+				return latest, nil
+			}
+			latest = conflictingComputeResource{azrsrc.NewFakeComputeResource(map[string]*string{}), &conflicts}
+
+			nodes := []nodeSync{{node: node, provider: provider, configOptions: &configOptions}}
+			err = reconciler.syncLabelsToAzureResource(context.Background(), latest, reload, nodes, nil)
+			assert.Equal(t, tt.expectedReads, reads)
+			if !tt.expectSuccess {
+				assert.True(t, azure.IsPreconditionFailed(err))
+				assert.Equal(t, 1, len(recorder.Events))
+				return
+			}
+			assert.NoError(t, err)
+			// the changes were applied on top of the latest tags
+			assert.Equal(t, "banana", *latest.Tags()[naming.TagWithPrefix("favfruit", options.DefaultTagPrefix)])
+			if tt.conflicts > 0 {
+				assert.Equal(t, "test", *latest.Tags()["env"])
+			}
 		})
 	}
 }
 
+func TestSubscriptionHealthStatus(t *testing.T) {
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{})
+	reconciler := NewFakeNodeLabelReconciler(policy)
+	reconciler.Health = azure.NewHealthTracker(FiveMinutes)
+	recorder := record.NewFakeRecorder(10)
+	reconciler.Recorder = recorder
+	provider := &azure.Resource{SubscriptionID: "sub", ResourceName: "vm1"}
+	nodes := []nodeSync{{node: NewFakeNode("node1", map[string]string{})}}
+	forbidden := func(scope string) error {
+		return autorest.DetailedError{StatusCode: http.StatusForbidden, Original: &autorestazure.RequestError{
+			ServiceError: &autorestazure.ServiceError{Code: "AuthorizationFailed",
+				Message: "The client 'id' does not have authorization to perform action 'read' over scope '" + scope + "'"},
+		}}
+	}
+
+	subscriptions := func() []v1alpha1.SubscriptionStatus {
+		var saved v1alpha1.NodeLabelSyncPolicy
+		assert.NoError(t, reconciler.Get(context.Background(), options.PolicyNamespacedName(), &saved))
+		return saved.Status.Subscriptions
+	}
+
+	assert.False(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, nil))
+	assert.Equal(t, 1, len(subscriptions()))
+	assert.True(t, subscriptions()[0].Healthy)
+
+	// 403s for a resource group are recorded on the nodes instead
+	assert.True(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, forbidden("/subscriptions/sub/resourceGroups/rg")))
+	assert.True(t, subscriptions()[0].Healthy)
+	assert.Contains(t, <-recorder.Events, "ResourceForbidden")
+
+	assert.True(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, forbidden("/subscriptions/sub")))
+	assert.Equal(t, 1, len(subscriptions()))
+	assert.False(t, subscriptions()[0].Healthy)
+	assert.Equal(t, azure.ForbiddenReason, subscriptions()[0].Reason)
+	assert.True(t, reconciler.Health.Allow("sub") > 0)
+
+	// errors of one resource don't change the status
+	notFound := autorest.DetailedError{StatusCode: http.StatusNotFound}
+	assert.True(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, notFound))
+	assert.False(t, subscriptions()[0].Healthy)
+	assert.Empty(t, recorder.Events)
+
+	assert.False(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, nil))
+	assert.True(t, subscriptions()[0].Healthy)
+	assert.Equal(t, "", subscriptions()[0].Reason)
+
+	// without any role in the subscription, ARM names each resource as the scope, so the subscription is
+	// unhealthy once several resources are forbidden, and the nodes on later ones aren't sent events
+	for i, name := range []string{"pool1", "pool2", "pool3", "pool4"} {
+		provider := &azure.Resource{SubscriptionID: "sub", ResourceGroup: "rg", ResourceType: azrsrc.VMSS, ResourceName: name}
+		scope := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/" + name
+		assert.True(t, reconciler.syncFailed(context.Background(), reconciler.Log, provider, nodes, forbidden(scope)))
+		if i < 2 {
+			assert.True(t, subscriptions()[0].Healthy)
+			assert.Contains(t, <-recorder.Events, "ResourceForbidden")
+		} else {
+			assert.False(t, subscriptions()[0].Healthy)
+			assert.Equal(t, azure.ForbiddenReason, subscriptions()[0].Reason)
+		}
+	}
+	assert.Empty(t, recorder.Events)
+}
+
 // test helper functions
 
-func NewFakeNodeLabelReconciler() *ReconcileNodeLabel {
+func NewFakeNodeLabelReconciler(initObjs ...runtime.Object) *ReconcileNodeLabel {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = v1alpha1.AddToScheme(s)
 	return &ReconcileNodeLabel{
-		Client:        ctrlfake.NewFakeClientWithScheme(scheme.Scheme),
+		Client:        ctrlfake.NewFakeClientWithScheme(s, initObjs...),
 		Log:           ctrl.Log.WithName("test"),
-		ctx:           context.Background(),
+		Recorder:      record.NewFakeRecorder(10),
 		MinSyncPeriod: FiveMinutes,
 	}
 }
 
+func NewFakeConfigMap(data map[string]string) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{Data: data}
+	configMap.Name = options.ConfigMapNamespacedName().Name
+	configMap.Namespace = options.ConfigMapNamespacedName().Namespace
+	return configMap
+}
+
+func NewFakePolicy(name string, spec v1alpha1.NodeLabelSyncPolicySpec) *v1alpha1.NodeLabelSyncPolicy {
+	policy := &v1alpha1.NodeLabelSyncPolicy{Spec: spec}
+	policy.Name = name
+	return policy
+}
+
 func NewFakeNode(name string, labels map[string]string) *corev1.Node {
 	node := &corev1.Node{}
 	node.Name = name
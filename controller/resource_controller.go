@@ -0,0 +1,192 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimecontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/azure"
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+)
+
+// field of the index of nodes by the VM or VMSS they run on
+const resourceKeyField string = "resourceKey"
+
+// return the key of the VM or VMSS the node runs on, see azure.Resource.Key, or nothing if the node doesn't
+// run on one
+func nodeResourceKeys(obj runtime.Object) []string {
+	node, ok := obj.(*corev1.Node)
+	if !ok || node.Spec.ProviderID == "" {
+		return nil
+	}
+	provider, err := azure.ParseProviderID(node.Spec.ProviderID)
+	if err != nil || !(strings.EqualFold(provider.ResourceType, azrsrc.VMSS) || strings.EqualFold(provider.ResourceType, azrsrc.VM)) {
+		return nil
+	}
+	return []string{provider.Key()}
+}
+
+func indexNodesByResource(mgr ctrl.Manager) error {
+	return mgr.GetFieldIndexer().IndexField(&corev1.Node{}, resourceKeyField, nodeResourceKeys)
+}
+
+// ReconcileComputeResource reconciles all the nodes on a VM or VMSS together, keyed by the resource's key. The
+// resource is read once for all its nodes, and their labels are written to it in one update per sync policy,
+// so writes for nodes on the same resource don't race.
+type ReconcileComputeResource struct {
+	*ReconcileNodeLabel
+}
+
+// +kubebuilder:rbac:groups=nodelabel.azure.com,resources=nodelabelsyncpolicies,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=nodelabel.azure.com,resources=nodelabelsyncpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=nodes/status,verbs=get
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+func (r *ReconcileComputeResource) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
+	log := r.Log.WithValues("resource", req.Name)
+
+	nodes, err := r.nodesWithResourceKey(ctx, req.Name)
+	if err != nil {
+		log.Error(err, "unable to list nodes on resource")
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	r.syncs.listed(req.Name, names)
+	if len(nodes) == 0 {
+		log.V(1).Info("no nodes on resource")
+		return ctrl.Result{}, nil
+	}
+	// the key is lowercase, so the resource's names are taken from a node
+	provider, err := azure.ParseProviderID(nodes[0].Spec.ProviderID)
+	if err != nil {
+		log.Error(err, "invalid provider ID", "node", nodes[0].Name)
+		return ctrl.Result{}, nil
+	}
+	provider.InstanceID = ""
+
+	if wait := r.Health.Allow(provider.SubscriptionID); wait > 0 {
+		log.V(1).Info("skipping resource in unhealthy subscription", "subscription", provider.SubscriptionID, "retry in", wait.String())
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	var synced []nodeSync
+	var siblings []corev1.Node
+	requeue := false
+	var retryIn time.Duration
+	for i := range nodes {
+		node := &nodes[i]
+		nodeProvider, err := azure.ParseProviderID(node.Spec.ProviderID)
+		if err != nil {
+			continue
+		}
+		// nodes synced recently are synced with the others once their minSyncPeriod is over
+		if wait := r.syncs.wait(node.Name); wait > 0 {
+			if retryIn == 0 || wait < retryIn {
+				retryIn = wait
+			}
+			siblings = append(siblings, *node)
+			continue
+		}
+		n, failed := r.nodeConfigOptions(ctx, log.WithValues("node", node.Name), node, &nodeProvider)
+		if n == nil {
+			requeue = requeue || failed
+			siblings = append(siblings, *node)
+			continue
+		}
+		synced = append(synced, *n)
+	}
+	log.V(1).Info("syncing nodes on resource", "nodes", len(synced), "other nodes", len(siblings))
+
+	if len(synced) > 0 {
+		if r.syncFailed(ctx, log, &provider, synced, r.syncComputeResource(ctx, &provider, synced, siblings)) {
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		for _, n := range synced {
+			r.syncs.synced(n.node.Name, r.minSyncPeriod(n.configOptions))
+		}
+	}
+
+	if requeue {
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	return ctrl.Result{RequeueAfter: retryIn}, nil
+}
+
+// enqueue the VM or VMSS the node runs on
+func (r *ReconcileComputeResource) resourceForNode(obj handler.MapObject) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, key := range nodeResourceKeys(obj.Object) {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: key}})
+	}
+	return requests
+}
+
+// enqueue every VM and VMSS with nodes when a sync policy changes, so new settings apply without waiting for minSyncPeriod
+func (r *ReconcileComputeResource) resourcesForPolicy(obj handler.MapObject) []reconcile.Request {
+	r.syncs.reset()
+	var nodeList corev1.NodeList
+	if err := r.List(context.Background(), &nodeList); err != nil {
+		r.Log.Error(err, "failed to list nodes for sync policy", "policy", obj.Meta.GetName())
+		return nil
+	}
+	seen := map[string]bool{}
+	var requests []reconcile.Request
+	for i := range nodeList.Items {
+		for _, key := range nodeResourceKeys(&nodeList.Items[i]) {
+			if !seen[key] {
+				seen[key] = true
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: key}})
+			}
+		}
+	}
+	r.Log.V(1).Info("sync policy changed, resyncing all resources", "policy", obj.Meta.GetName(), "resources", len(requests))
+	return requests
+}
+
+// node events are mapped to the resource the node runs on, so there's no For type for the controller builder,
+// and the builder doesn't take options in this version of controller-runtime to set MaxConcurrentReconciles
+func (r *ReconcileComputeResource) SetupWithManager(mgr ctrl.Manager) error {
+	if err := indexNodesByResource(mgr); err != nil {
+		return err
+	}
+	if err := r.cancelOnStop(mgr); err != nil {
+		return err
+	}
+	if err := mgr.Add(manager.RunnableFunc(r.removeLegacyLabels)); err != nil {
+		return err
+	}
+	c, err := runtimecontroller.New("computeresource", mgr, runtimecontroller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Node{}},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.resourceForNode)}, eventFilter); err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &v1alpha1.NodeLabelSyncPolicy{}},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.resourcesForPolicy)}, eventFilter)
+}
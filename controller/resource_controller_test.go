@@ -0,0 +1,349 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/azure"
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+const testScaleSetID string = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool1"
+
+type nullCredentials struct{}
+
+func (nullCredentials) Authorizer(string) (autorest.Authorizer, error) {
+	return autorest.NullAuthorizer{}, nil
+}
+
+// ARM serving one scale set, counting reads and writes of it. Every write changes the scale set's ETag, and
+// writes with an If-Match that doesn't match fail with 412.
+type fakeScaleSetServer struct {
+	lock    sync.Mutex
+	tags    map[string]string
+	version int
+	noETag  bool              // don't return ETags, like some compute API versions
+	changed map[string]string // tags set by another writer right after the first read
+	reads   int
+	writes  int
+	refused int
+}
+
+func (s *fakeScaleSetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !strings.EqualFold(r.URL.Path, testScaleSetID) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf(`"%d"`, s.version)
+	switch r.Method {
+	case http.MethodGet:
+		s.reads++
+		if s.reads == 1 && s.changed != nil {
+			defer s.write(s.changed)
+		}
+	case http.MethodPatch:
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+			s.refused++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"code": "PreconditionFailed"}})
+			return
+		}
+		s.writes++
+		var update struct {
+			Tags map[string]string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.write(update.Tags)
+		etag = fmt.Sprintf(`"%d"`, s.version)
+	}
+	if !s.noETag {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": testScaleSetID, "name": "pool1", "tags": s.tags})
+}
+
+func (s *fakeScaleSetServer) write(tags map[string]string) {
+	s.tags = tags
+	s.version++
+}
+
+func TestNodeResourceKeys(t *testing.T) {
+	var keyTest = []struct {
+		name       string
+		providerID string
+		expected   []string
+	}{
+		{"scale set instance", "azure://" + testScaleSetID + "/virtualMachines/0", []string{strings.ToLower(testScaleSetID)}},
+		{"VM", "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1",
+			[]string{"/subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/virtualmachines/vm1"}},
+		{"other resource type", "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk1", nil},
+		{"no provider ID", "", nil},
+	}
+
+	for _, tt := range keyTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node1", map[string]string{})
+			node.Spec.ProviderID = tt.providerID
+			assert.Equal(t, tt.expected, nodeResourceKeys(node))
+		})
+	}
+}
+
+func TestResourcesForPolicy(t *testing.T) {
+	node1 := NewFakeNode("node1", map[string]string{})
+	node1.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/0"
+	node2 := NewFakeNode("node2", map[string]string{})
+	node2.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/1"
+	node3 := NewFakeNode("node3", map[string]string{})
+	node3.Spec.ProviderID = "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{})
+	reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(node1, node2, node3, policy)}
+
+	requests := reconciler.resourcesForPolicy(handler.MapObject{Meta: policy, Object: policy})
+	var keys []string
+	for _, req := range requests {
+		keys = append(keys, req.Name)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{
+		"/subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/virtualmachines/vm1",
+		strings.ToLower(testScaleSetID),
+	}, keys)
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}}},
+		reconciler.resourceForNode(handler.MapObject{Meta: node2, Object: node2}))
+}
+
+func TestReconcileComputeResource(t *testing.T) {
+	arm := &fakeScaleSetServer{tags: map[string]string{"env": "test"}}
+	server := httptest.NewServer(arm)
+	defer server.Close()
+
+	node1 := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+	node1.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/0"
+	node2 := NewFakeNode("node2", map[string]string{"favfruit": "banana"})
+	node2.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/1"
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{
+		SyncDirection: string(options.TwoWay),
+		InstanceTags:  string(options.IgnoreInstanceTags),
+	})
+	reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(node1, node2, policy)}
+	reconciler.Clients = azure.NewClients(autorestazure.Environment{ResourceManagerEndpoint: server.URL}, nullCredentials{}, nil)
+
+	result, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+
+	// the scale set is read once and written once for both nodes
+	assert.Equal(t, 1, arm.writes)
+	assert.Equal(t, "banana", arm.tags["node.labels.favfruit"])
+	assert.Equal(t, "test", arm.tags["env"])
+	for _, name := range []string{"node1", "node2"} {
+		var node corev1.Node
+		require.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: name}, &node))
+		assert.Equal(t, "test", node.Labels["azure.tags/env"])
+	}
+	reads := arm.reads
+
+	// the nodes were just synced, so the resource is synced again after minSyncPeriod
+	result, err = reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+	assert.True(t, result.RequeueAfter > 0 && result.RequeueAfter <= FiveMinutes)
+	assert.Equal(t, reads, arm.reads)
+
+	// nothing changed, so there's no write
+	reconciler.syncs.reset()
+	_, err = reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, arm.writes)
+	assert.Equal(t, 1, arm.reads-reads)
+
+	// a resource without nodes is skipped
+	_, err = reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "/subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/virtualmachines/vm1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, arm.reads-reads)
+
+	// deleted nodes are forgotten
+	require.NoError(t, reconciler.Delete(context.Background(), node2))
+	_, err = reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+	assert.True(t, reconciler.syncs.wait("node1") > 0)
+	assert.Equal(t, time.Duration(0), reconciler.syncs.wait("node2"))
+}
+
+func TestReconcileComputeResourceMixedPolicies(t *testing.T) {
+	arm := &fakeScaleSetServer{tags: map[string]string{}}
+	server := httptest.NewServer(arm)
+	defer server.Close()
+
+	node1 := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+	node1.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/0"
+	node2 := NewFakeNode("node2", map[string]string{"pool": "gpu"})
+	node2.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/1"
+	defaultPolicy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{
+		SyncDirection: string(options.NodeToARM),
+	})
+	gpuPolicy := NewFakePolicy("gpu", v1alpha1.NodeLabelSyncPolicySpec{
+		NodeSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}},
+		Priority:      10,
+		SyncDirection: string(options.NodeToARM),
+		TagPrefix:     to.StringPtr("gpu.labels"),
+	})
+	reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(node1, node2, defaultPolicy, gpuPolicy)}
+	reconciler.Clients = azure.NewClients(autorestazure.Environment{ResourceManagerEndpoint: server.URL}, nullCredentials{}, nil)
+
+	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+
+	// each policy writes the labels of the nodes on the scale set with its own tag prefix, as when syncing one node
+	assert.Equal(t, 2, arm.writes)
+	assert.Equal(t, "banana", arm.tags["node.labels.favfruit"])
+	assert.Equal(t, "gpu", arm.tags["gpu.labels.pool"])
+	assert.Equal(t, 0, arm.refused)
+}
+
+// run with -race to check that reconciles share no state but the schedule, clients and caches, which are locked
+func TestReconcileComputeResourceMigratesTags(t *testing.T) {
+	arm := &fakeScaleSetServer{tags: map[string]string{"favfruit": "banana", "env": "test"}}
+	server := httptest.NewServer(arm)
+	defer server.Close()
+
+	// favfruit was written by an earlier version without the tag prefix, env was added by other means
+	node1 := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+	node1.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/0"
+	node1.Annotations = map[string]string{labelsync.OwnedTagsAnnotation: `["favfruit"]`}
+	policy := NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{
+		SyncDirection: string(options.TwoWay),
+	})
+	reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(node1, policy)}
+	reconciler.Clients = azure.NewClients(autorestazure.Environment{ResourceManagerEndpoint: server.URL}, nullCredentials{}, nil)
+
+	_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"node.labels.favfruit": "banana", "env": "test"}, arm.tags)
+}
+
+func TestConcurrentReconciles(t *testing.T) {
+	arm := &fakeScaleSetServer{tags: map[string]string{"env": "test"}}
+	server := httptest.NewServer(arm)
+	defer server.Close()
+
+	objs := []runtime.Object{NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{
+		SyncDirection: string(options.TwoWay),
+		InstanceTags:  string(options.IgnoreInstanceTags),
+	})}
+	var names []string
+	for i := 0; i < 8; i++ {
+		node := NewFakeNode(fmt.Sprintf("node%d", i), map[string]string{fmt.Sprintf("fruit%d", i): "banana"})
+		node.Spec.ProviderID = fmt.Sprintf("azure://%s/virtualMachines/%d", testScaleSetID, i)
+		objs = append(objs, node)
+		names = append(names, node.Name)
+	}
+	reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(objs...)}
+	reconciler.Clients = azure.NewClients(autorestazure.Environment{ResourceManagerEndpoint: server.URL}, nullCredentials{}, nil)
+	reconciler.Cache = azrsrc.NewCache(time.Minute)
+	reconciler.Health = azure.NewHealthTracker(FiveMinutes)
+	reconciler.ReconcileTimeout = time.Minute
+
+	var wg sync.WaitGroup
+	for range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		var node corev1.Node
+		require.NoError(t, reconciler.Get(context.Background(), types.NamespacedName{Name: name}, &node))
+		assert.Equal(t, "test", node.Labels["azure.tags/env"])
+		assert.True(t, reconciler.syncs.wait(name) > 0)
+	}
+	// syncs of the scale set wait for each other, so no node's label is lost to another node's write
+	for i := range names {
+		assert.Equal(t, "banana", arm.tags[fmt.Sprintf("node.labels.fruit%d", i)])
+	}
+	assert.Equal(t, "test", arm.tags["env"])
+	assert.Equal(t, 0, arm.refused)
+}
+
+func TestTagWriteConflict(t *testing.T) {
+	var conflictTest = []struct {
+		name   string
+		noETag bool
+	}{
+		{"ETag", false},
+		{"no ETag", true},
+	}
+
+	for _, tt := range conflictTest {
+		t.Run(tt.name, func(t *testing.T) {
+			arm := &fakeScaleSetServer{
+				tags:    map[string]string{"env": "test"},
+				noETag:  tt.noETag,
+				changed: map[string]string{"env": "test", "owner": "other"},
+			}
+			server := httptest.NewServer(arm)
+			defer server.Close()
+
+			node := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+			node.Spec.ProviderID = "azure://" + testScaleSetID + "/virtualMachines/0"
+			reconciler := &ReconcileComputeResource{NewFakeNodeLabelReconciler(node, NewFakePolicy(options.DefaultPolicyName, v1alpha1.NodeLabelSyncPolicySpec{
+				SyncDirection: string(options.NodeToARM),
+				InstanceTags:  string(options.IgnoreInstanceTags),
+			}))}
+			reconciler.Clients = azure.NewClients(autorestazure.Environment{ResourceManagerEndpoint: server.URL}, nullCredentials{}, nil)
+			reconciler.Cache = azrsrc.NewCache(time.Minute)
+
+			_, err := reconciler.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: strings.ToLower(testScaleSetID)}})
+			require.NoError(t, err)
+
+			// the write of the tags read before the other writer's change is refused, and the retry keeps both
+			if tt.noETag {
+				assert.Equal(t, 0, arm.refused)
+			} else {
+				assert.Equal(t, 1, arm.refused)
+			}
+			assert.Equal(t, 1, arm.writes)
+			assert.Equal(t, map[string]string{"env": "test", "owner": "other", "node.labels.favfruit": "banana"}, arm.tags)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// syncSchedule keeps when each node was last synced, and the minSyncPeriod it was synced with, so nodes
+// aren't synced more often than their policy allows. It's kept in memory, so all nodes are synced again
+// when the controller restarts. The zero value is ready to use.
+type syncSchedule struct {
+	lock  sync.Mutex
+	syncs map[string]lastSync
+	// nodes last listed on each resource
+	resources map[string][]string
+	now       func() time.Time
+}
+
+type lastSync struct {
+	at     time.Time
+	period time.Duration
+}
+
+func (s *syncSchedule) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+// return how long until the node can be synced again, 0 if it can be synced now
+func (s *syncSchedule) wait(node string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	last, ok := s.syncs[node]
+	if !ok {
+		return 0
+	}
+	if wait := last.at.Add(last.period).Sub(s.clock()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// record that the node was synced now, and shouldn't be synced again for period
+func (s *syncSchedule) synced(node string, period time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.syncs == nil {
+		s.syncs = map[string]lastSync{}
+	}
+	s.syncs[node] = lastSync{at: s.clock(), period: period}
+}
+
+// record the nodes now on the resource with the given key, and forget the nodes that were on it before but
+// no longer are, since deleted nodes aren't reconciled on their own
+func (s *syncSchedule) listed(key string, nodes []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := map[string]bool{}
+	for _, node := range nodes {
+		current[node] = true
+	}
+	for _, node := range s.resources[key] {
+		if !current[node] {
+			delete(s.syncs, node)
+		}
+	}
+	if len(nodes) == 0 {
+		delete(s.resources, key)
+		return
+	}
+	if s.resources == nil {
+		s.resources = map[string][]string{}
+	}
+	s.resources[key] = nodes
+}
+
+// let all nodes be synced right away, ex. when their sync policy changed
+func (s *syncSchedule) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.syncs = nil
+}
@@ -0,0 +1,120 @@
+package labelsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+// AggregateLabels combines the labels of node and the other nodes on the same Azure resource using the
+// configured aggregation rule, and returns a copy of node with the combined labels. Labels excluded by the
+// label filter are left out. Labels with the label prefix were synced from the resource's tags, so they're
+// taken from node alone, and the other nodes' copies, which may be older, can't recreate deleted tags. With
+// the unanimous and majority rules, which leave out the values of some nodes, an event is raised on node for
+// each label the nodes disagree on, naming the nodes that disagree. Other rules only log disagreements.
+func AggregateLabels(node *corev1.Node, siblings []corev1.Node, configOptions *options.ConfigOptions,
+	log logr.Logger, recorder record.EventRecorder) *corev1.Node {
+
+	aggregated := node.DeepCopy()
+	if len(siblings) == 0 {
+		return aggregated
+	}
+
+	nodes := []*corev1.Node{node}
+	for i := range siblings {
+		nodes = append(nodes, &siblings[i])
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	leader := nodes[0]
+
+	// label name -> label value -> names of nodes with that value
+	values := map[string]map[string][]string{}
+	for _, n := range nodes {
+		for labelName, labelVal := range n.Labels {
+			// labels that are never written to the Azure resource aren't compared
+			if !configOptions.LabelFilter.Allowed(labelName) ||
+				!naming.ValidTagName(labelName, configOptions.LabelPrefix) || !naming.ValidTagVal(labelVal) ||
+				naming.HasLabelPrefix(labelName, configOptions.LabelPrefix) {
+				continue
+			}
+			if _, ok := values[labelName]; !ok {
+				values[labelName] = map[string][]string{}
+			}
+			values[labelName][labelVal] = append(values[labelName][labelVal], n.Name)
+		}
+	}
+
+	aggregated.Labels = map[string]string{}
+	for labelName, labelVal := range node.Labels {
+		if naming.HasLabelPrefix(labelName, configOptions.LabelPrefix) {
+			aggregated.Labels[labelName] = labelVal
+		}
+	}
+	// with any and leader, nodes are expected to differ, so disagreements would raise the same events on every sync
+	report := configOptions.LabelAggregation == options.Unanimous || configOptions.LabelAggregation == options.Majority
+	for labelName, labelVals := range values {
+		labelVal := mostCommonValue(labelVals)
+		count := len(labelVals[labelVal])
+		include := false
+		switch configOptions.LabelAggregation {
+		case options.Unanimous:
+			include = count == len(nodes)
+		case options.Majority:
+			include = count*2 > len(nodes)
+		case options.Any:
+			include = len(labelVals) == 1
+		case options.Leader:
+			labelVal, include = leader.Labels[labelName]
+		}
+
+		var disagreeing []string
+		for _, n := range nodes {
+			val, ok := n.Labels[labelName]
+			if (include && ok && val != labelVal) || (!include && val != labelVal) {
+				disagreeing = append(disagreeing, n.Name)
+			}
+		}
+
+		if include {
+			aggregated.Labels[labelName] = labelVal
+			if len(disagreeing) > 0 {
+				log.V(1).Info("nodes disagree on label, applying to Azure resource", "label name", labelName,
+					"label value", labelVal, "aggregation", configOptions.LabelAggregation, "nodes", disagreeing)
+				if report {
+					recorder.Event(node, "Warning", "LabelAggregationDisagreement",
+						fmt.Sprintf("node label '%s' was applied to Azure resource with value '%s', but nodes %s have a different value",
+							labelName, labelVal, strings.Join(disagreeing, ", ")))
+				}
+			}
+		} else {
+			log.V(1).Info("nodes disagree on label, not applying to Azure resource", "label name", labelName,
+				"aggregation", configOptions.LabelAggregation, "nodes", disagreeing)
+			if report {
+				recorder.Event(node, "Warning", "LabelAggregationDisagreement",
+					fmt.Sprintf("node label '%s' was not applied to Azure resource because nodes %s disagree (label aggregation is %s)",
+						labelName, strings.Join(disagreeing, ", "), configOptions.LabelAggregation))
+			}
+		}
+	}
+
+	return aggregated
+}
+
+// value held by the most nodes, ties broken by the smallest value so the result is deterministic
+func mostCommonValue(labelVals map[string][]string) string {
+	best, found := "", false
+	for val, nodeNames := range labelVals {
+		if !found || len(nodeNames) > len(labelVals[best]) ||
+			(len(nodeNames) == len(labelVals[best]) && val < best) {
+			best, found = val, true
+		}
+	}
+	return best
+}
@@ -0,0 +1,112 @@
+package labelsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+func TestAggregateLabels(t *testing.T) {
+	// node-a is the leader, node-b is the node being reconciled
+	nodeLabels := map[string]map[string]string{
+		"node-a": {"env": "test", "favfruit": "banana", "leader": "true"},
+		"node-b": {"env": "test", "favfruit": "apple", "gpu": "true"},
+		"node-c": {"env": "test", "favfruit": "banana"},
+	}
+
+	var aggregateTest = []struct {
+		name           string
+		aggregation    options.LabelAggregation
+		expectedLabels map[string]string
+		expectedEvents int
+	}{
+		{
+			"unanimous",
+			options.Unanimous,
+			map[string]string{"env": "test"},
+			3, // favfruit, gpu, leader
+		},
+		{
+			"majority",
+			options.Majority,
+			map[string]string{"env": "test", "favfruit": "banana"},
+			3, // favfruit written over node-b, gpu, leader
+		},
+		{
+			"any",
+			options.Any,
+			map[string]string{"env": "test", "gpu": "true", "leader": "true"},
+			0, // only logged
+		},
+		{
+			"leader",
+			options.Leader,
+			map[string]string{"env": "test", "favfruit": "banana", "leader": "true"},
+			0, // only logged
+		},
+	}
+
+	for _, tt := range aggregateTest {
+		t.Run(tt.name, func(t *testing.T) {
+			configOptions := options.DefaultConfigOptions()
+			configOptions.LabelAggregation = tt.aggregation
+			configOptions.LabelPrefix = ""
+			node := NewFakeNode("node-b", nodeLabels["node-b"])
+			siblings := []corev1.Node{*NewFakeNode("node-c", nodeLabels["node-c"]), *NewFakeNode("node-a", nodeLabels["node-a"])}
+			recorder := record.NewFakeRecorder(10)
+
+			aggregated := AggregateLabels(node, siblings, &configOptions, ctrl.Log, recorder)
+			assert.Equal(t, tt.expectedLabels, aggregated.Labels)
+			assert.Equal(t, "node-b", aggregated.Name)
+			assert.Equal(t, tt.expectedEvents, len(recorder.Events))
+			assert.Equal(t, nodeLabels["node-b"], node.Labels) // node itself is unchanged
+		})
+	}
+}
+
+func TestAggregateLabelsSingleNode(t *testing.T) {
+	configOptions := options.DefaultConfigOptions()
+	configOptions.LabelAggregation = options.Unanimous
+	node := NewFakeNode("node", map[string]string{"env": "test"})
+	recorder := record.NewFakeRecorder(10)
+	aggregated := AggregateLabels(node, nil, &configOptions, ctrl.Log, recorder)
+	assert.Equal(t, node.Labels, aggregated.Labels)
+	assert.Equal(t, 0, len(recorder.Events))
+}
+
+func TestAggregateLabelsFiltered(t *testing.T) {
+	configOptions := options.DefaultConfigOptions()
+	configOptions.LabelAggregation = options.Unanimous
+	configOptions.LabelPrefix = ""
+	configOptions.LabelFilter.Exclude = []naming.Pattern{{Glob: "favfruit"}}
+	node := NewFakeNode("node-a", map[string]string{"env": "test", "favfruit": "banana"})
+	siblings := []corev1.Node{*NewFakeNode("node-b", map[string]string{"env": "test", "favfruit": "apple"})}
+	recorder := record.NewFakeRecorder(10)
+
+	// the nodes only disagree on a filtered label, so there's no event
+	aggregated := AggregateLabels(node, siblings, &configOptions, ctrl.Log, recorder)
+	assert.Equal(t, map[string]string{"env": "test"}, aggregated.Labels)
+	assert.Equal(t, 0, len(recorder.Events))
+}
+
+func TestAggregateLabelsSyncedFromTags(t *testing.T) {
+	configOptions := options.DefaultConfigOptions()
+	configOptions.LabelAggregation = options.Unanimous
+	envLabel := naming.LabelWithPrefix("env", options.DefaultLabelPrefix)
+	fruitLabel := naming.LabelWithPrefix("favfruit", options.DefaultLabelPrefix)
+	node := NewFakeNode("node-a", map[string]string{"favveg": "kale", envLabel: "prod"})
+	// node-b hasn't been synced since favfruit's tag was deleted and env's tag changed
+	siblings := []corev1.Node{*NewFakeNode("node-b", map[string]string{"favveg": "kale", envLabel: "test", fruitLabel: "banana"})}
+	recorder := record.NewFakeRecorder(10)
+
+	// labels synced from tags are taken from the node alone
+	aggregated := AggregateLabels(node, siblings, &configOptions, ctrl.Log, recorder)
+	assert.Equal(t, map[string]string{"favveg": "kale", envLabel: "prod"}, aggregated.Labels)
+	assert.Equal(t, 0, len(recorder.Events))
+}
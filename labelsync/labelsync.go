@@ -3,6 +3,8 @@ package labelsync
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/go-logr/logr"
@@ -15,85 +17,188 @@ import (
 	"github.com/Azure/node-label-operator/labelsync/options"
 )
 
-// return patch with new labels, if any, otherwise return nil for no new labels or an error
+// return patch with new labels and annotations, if any, otherwise return nil for no changes or an error
 func TagsToNodes(namespacedName types.NamespacedName, computeResource azrsrc.ComputeResource,
 	node *corev1.Node, configOptions *options.ConfigOptions, log logr.Logger, recorder record.EventRecorder) ([]byte, error) {
 
-	newLabels := map[string]*string{} // should allow for null JSON values
-	for tagName, tagVal := range computeResource.Tags() {
-		if !naming.ValidLabelName(tagName) {
-			log.V(0).Info("invalid label name", "tag name", tagName)
+	_, collisions := tagLabelNames(computeResource.Tags(), configOptions)
+	for labelName, tagNames := range collisions {
+		log.V(0).Info("tags map to the same label name", "label name", labelName, "tag names", tagNames,
+			"collision policy", configOptions.CollisionPolicy)
+		recorder.Event(node, "Warning", "TagNameCollision",
+			fmt.Sprintf("ARM tags %s map to the same label name '%s', resolved with collision policy %s.",
+				strings.Join(tagNames, ", "), labelName, configOptions.CollisionPolicy))
+	}
+
+	// tags are synced to labels, annotations or both. For the target that isn't synced to, an empty
+	// set of tags is used so that anything previously synced there is deleted.
+	noTags := map[string]*string{}
+
+	labelTags := noTags
+	if configOptions.TagTarget != options.AnnotationTarget {
+		labelTags = computeResource.Tags()
+	}
+	newLabels, err := tagsToMetadata(labelTags, node, node.Labels, configOptions.Transform.TagValToLabelVal, naming.ValidLabelVal,
+		"label", configOptions, log, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	annotationTags := noTags
+	if configOptions.TagTarget != options.LabelTarget {
+		annotationTags = computeResource.Tags()
+	}
+	newAnnotations, err := tagsToMetadata(annotationTags, node, node.Annotations, configOptions.Transform.TagValToAnnotationVal, naming.ValidAnnotationVal,
+		"annotation", configOptions, log, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(newLabels) == 0 && len(newAnnotations) == 0 { // to avoid unnecessary patching
+		return nil, nil
+	}
+
+	patch, err := MetadataPatch(newLabels, newAnnotations)
+	if err != nil {
+		return nil, err
+	}
+
+	return patch, nil
+}
+
+// return the labels or annotations (kind) to set for the given tags, with nil values for deletions
+func tagsToMetadata(tags map[string]*string, node *corev1.Node, existing map[string]string, convertVal func(string) string,
+	validVal func(string) bool, kind string, configOptions *options.ConfigOptions, log logr.Logger,
+	recorder record.EventRecorder) (map[string]*string, error) {
+
+	newMetadata := map[string]*string{} // should allow for null JSON values
+	synced := map[string]bool{}         // names of labels or annotations that tags map to
+	names, _ := tagLabelNames(tags, configOptions)
+	for tagName, name := range names {
+		tagVal := tags[tagName]
+		if _, ok := node.Labels[name]; ok && naming.HasTagPrefix(tagName, configOptions.TagPrefix) {
+			// written from the node's own label, so syncing it back would only echo the label
 			continue
 		}
-		if !naming.ValidLabelVal(*tagVal) {
-			log.V(0).Info("invalid label value", "tag value", *tagVal)
+		validName := naming.LabelWithPrefix(name, configOptions.LabelPrefix)
+		synced[validName] = true
+		if !naming.ValidLabelName(name) {
+			log.V(0).Info("invalid "+kind+" name", "tag name", tagName)
 			continue
 		}
-		validLabelName := naming.ConvertTagNameToValidLabelName(tagName, configOptions.LabelPrefix)
-		labelVal, ok := node.Labels[validLabelName]
+		newVal := convertVal(*tagVal)
+		if !validVal(newVal) {
+			log.V(0).Info("invalid "+kind+" value", "tag value", *tagVal)
+			continue
+		}
+		val, ok := existing[validName]
 		if !ok {
-			// add tag as label
-			log.V(1).Info("applying tags to nodes", "tag name", tagName, "tag value", *tagVal)
-			newLabels[validLabelName] = tagVal
-		} else if labelVal != *tagVal {
+			// add tag as label or annotation
+			log.V(1).Info("applying tags to nodes", "tag name", tagName, "tag value", *tagVal, "target", kind)
+			newMetadata[validName] = to.StringPtr(newVal)
+		} else if val != newVal {
 			switch configOptions.ConflictPolicy {
 			case options.ARMPrecedence:
-				// set label anyway
-				log.V(1).Info("overriding existing node label with ARM tag", "tag name", tagName, "tag value", tagVal)
-				newLabels[validLabelName] = tagVal
+				// set anyway
+				log.V(1).Info("overriding existing node "+kind+" with ARM tag", "tag name", tagName, "tag value", *tagVal)
+				newMetadata[validName] = to.StringPtr(newVal)
 			case options.NodePrecedence:
 				// do nothing
-				log.V(0).Info("name->value conflict found", "node label value", labelVal, "ARM tag value", *tagVal)
+				log.V(0).Info("name->value conflict found", "node "+kind+" value", val, "ARM tag value", *tagVal)
 			case options.Ignore:
 				// raise k8s event
 				recorder.Event(node, "Warning", "ConflictingTagLabelValues",
-					fmt.Sprintf("ARM tag was not applied to node because a different value for '%s' already exists (%s != %s).", tagName, *tagVal, labelVal))
-				log.V(0).Info("name->value conflict found, leaving unchanged", "label value", labelVal, "tag value", *tagVal)
+					fmt.Sprintf("ARM tag was not applied to node because a different value for '%s' already exists (%s != %s).", tagName, newVal, val))
+				log.V(0).Info("name->value conflict found, leaving unchanged", kind+" value", val, "tag value", *tagVal)
 			default:
 				return nil, errors.New("unrecognized conflict policy")
 			}
 		}
 	}
 
-	// delete labels if tag has been deleted
+	// delete labels or annotations if tag has been deleted
 	// if conflict policy is node precedence (which it will most likely not be), then don't delete tags if they exist on node
 	if labelDeletionAllowed(configOptions) {
-		for labelFullName, labelVal := range node.Labels {
-			if naming.HasLabelPrefix(labelFullName, configOptions.LabelPrefix) {
-				// check if exists on vm/vmss
-				labelName := naming.LabelWithoutPrefix(labelFullName, configOptions.LabelPrefix)
-				_, ok := computeResource.Tags()[labelName]
-				if !ok { // if label doesn't exist on ARM resource, delete
-					log.V(1).Info("deleting label from node", "label name", labelFullName, "label value", labelVal)
-					delete(node.Labels, labelFullName) // for some reason this is needed
-					newLabels[labelFullName] = nil     // this should becomes 'null' in JSON, necessary for merge patch
+		for fullName, val := range existing {
+			if naming.HasLabelPrefix(fullName, configOptions.LabelPrefix) {
+				// check if a tag on vm/vmss maps to it
+				if !synced[fullName] { // if tag doesn't exist on ARM resource, delete
+					log.V(1).Info("deleting "+kind+" from node", "name", fullName, "value", val)
+					delete(existing, fullName)  // for some reason this is needed
+					newMetadata[fullName] = nil // this should becomes 'null' in JSON, necessary for merge patch
 				}
 			}
 		}
 	}
 
-	if len(newLabels) == 0 { // to avoid unnecessary patching
-		return nil, nil
-	}
+	return newMetadata, nil
+}
 
-	patch, err := LabelPatchWithDelete(newLabels)
-	if err != nil {
-		return nil, err
+// return the label name, without the label prefix, for each tag synced to nodes, and the tags that map to
+// the same label name. Colliding tags are resolved with the collision policy.
+func tagLabelNames(tags map[string]*string, configOptions *options.ConfigOptions) (map[string]string, map[string][]string) {
+	byLabelName := map[string][]string{}
+	fromLabels := map[string][]string{}
+	for tagName := range tags {
+		if !configOptions.TagFilter.Allowed(tagName) {
+			continue
+		}
+		if naming.HasTagPrefix(tagName, configOptions.TagPrefix) {
+			// tags with the tag prefix were written from node labels, so they're synced back under the label's name
+			labelName := naming.ConvertTagNameToValidLabelName(naming.TagWithoutPrefix(tagName, configOptions.TagPrefix), "")
+			fromLabels[labelName] = append(fromLabels[labelName], tagName)
+			continue
+		}
+		labelName := naming.ConvertTagNameToValidLabelName(configOptions.Transform.TagNameToLabelName(tagName), "")
+		byLabelName[labelName] = append(byLabelName[labelName], tagName)
+	}
+	// other tags take precedence over the tags written from node labels
+	for labelName, tagNames := range fromLabels {
+		if _, ok := byLabelName[labelName]; !ok {
+			byLabelName[labelName] = tagNames
+		}
 	}
 
-	return patch, nil
+	names := map[string]string{}
+	collisions := map[string][]string{}
+	for labelName, tagNames := range byLabelName {
+		if len(tagNames) == 1 {
+			names[tagNames[0]] = labelName
+			continue
+		}
+		sort.Strings(tagNames)
+		collisions[labelName] = tagNames
+		switch configOptions.CollisionPolicy {
+		case options.FirstWins:
+			names[tagNames[0]] = labelName
+		case options.HashCollisions:
+			for _, tagName := range tagNames {
+				names[tagName] = naming.HashTruncate(labelName, tagName, naming.MaxLabelNameLen)
+			}
+		case options.SkipCollisions:
+			// none of the tags are synced
+		}
+	}
+	return names, collisions
 }
 
 func LabelsToAzureResource(namespacedName types.NamespacedName, computeResource azrsrc.ComputeResource,
 	node *corev1.Node, configOptions *options.ConfigOptions, log logr.Logger, recorder record.EventRecorder) (map[string]*string, error) {
 
 	if len(computeResource.Tags()) >= naming.MaxNumTags {
+		// tags for removed labels can still be deleted, see StaleTags
 		log.V(0).Info("can't add any more tags", "number of tags", len(computeResource.Tags()))
-		return computeResource.Tags(), nil
+		return nil, nil
 	}
 
+	tagsByLabelName := labelSources(computeResource.Tags(), configOptions)
+
 	newTags := map[string]*string{}
 	for labelName, labelVal := range node.Labels {
+		if !configOptions.LabelFilter.Allowed(labelName) {
+			log.V(2).Info("label excluded by label filter", "label name", labelName)
+			continue
+		}
 		if !naming.ValidTagName(labelName, configOptions.LabelPrefix) {
 			log.V(2).Info("invalid tag name", "label name", labelName)
 			continue
@@ -102,18 +207,22 @@ func LabelsToAzureResource(namespacedName types.NamespacedName, computeResource
 			log.V(2).Info("invalid tag name", "label name", labelName)
 			continue
 		}
-		validTagName := naming.ConvertLabelNameToValidTagName(labelName, configOptions.LabelPrefix)
+		validTagName, newVal := labelToTag(labelName, labelVal, computeResource.Tags(), tagsByLabelName, configOptions)
+		if len(validTagName) > naming.MaxTagNameLen {
+			log.V(2).Info("invalid tag name", "tag name", validTagName)
+			continue
+		}
 		tagVal, ok := computeResource.Tags()[validTagName]
 		if !ok {
 			// add label as tag
 			log.V(1).Info("applying labels to Azure resource", "label name", labelName, "label value", labelVal)
-			newTags[validTagName] = to.StringPtr(labelVal)
-		} else if *tagVal != labelVal {
+			newTags[validTagName] = to.StringPtr(newVal)
+		} else if *tagVal != newVal {
 			switch configOptions.ConflictPolicy {
 			case options.NodePrecedence:
 				// set tag anyway
 				log.V(1).Info("overriding existing ARM tag with node label", "label name", labelName, "label value", labelVal)
-				newTags[validTagName] = to.StringPtr(labelVal)
+				newTags[validTagName] = to.StringPtr(newVal)
 			case options.ARMPrecedence:
 				// do nothing
 				log.V(0).Info("name->value conflict found", "node label value", labelVal, "ARM tag value", *tagVal)
@@ -134,3 +243,42 @@ func LabelsToAzureResource(namespacedName types.NamespacedName, computeResource
 
 	return newTags, nil
 }
+
+// return the tag each label name, without the label prefix, was created from
+func labelSources(tags map[string]*string, configOptions *options.ConfigOptions) map[string]string {
+	names, _ := tagLabelNames(tags, configOptions)
+	tagsByLabelName := map[string]string{}
+	for tagName, labelName := range names {
+		tagsByLabelName[labelName] = tagName
+	}
+	return tagsByLabelName
+}
+
+// labels with the label prefix were created from ARM tags, so they're mapped back to the tag they were created
+// from, or through the reverse of the tag transform, keeping the original tag value where it still transforms
+// to the label value
+func labelToTag(labelName, labelVal string, tags map[string]*string, tagsByLabelName map[string]string,
+	configOptions *options.ConfigOptions) (string, string) {
+
+	if !naming.HasLabelPrefix(labelName, configOptions.LabelPrefix) {
+		return naming.ConvertLabelNameToValidTagName(labelName, configOptions.LabelPrefix, configOptions.TagPrefix), labelVal
+	}
+	name := naming.LabelWithoutPrefix(labelName, configOptions.LabelPrefix)
+	tagName, ok := tagsByLabelName[name]
+	if !ok {
+		tagName = configOptions.Transform.LabelNameToTagName(name, nil)
+	}
+	return tagName, configOptions.Transform.LabelValToTagVal(labelVal, tags[tagName])
+}
+
+// remove all labels with the given prefix from node, returning them with nil values for a merge patch
+func DeleteLabelsWithPrefix(node *corev1.Node, labelPrefix string) map[string]*string {
+	deleted := map[string]*string{}
+	for labelFullName := range node.Labels {
+		if naming.HasLabelPrefix(labelFullName, labelPrefix) {
+			delete(node.Labels, labelFullName)
+			deleted[labelFullName] = nil
+		}
+	}
+	return deleted
+}
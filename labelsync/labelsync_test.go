@@ -7,6 +7,7 @@ import (
 
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -136,6 +137,257 @@ func TestCorrectTagsAppliedToNodes(t *testing.T) {
 	}
 }
 
+func TestTagPrefixedTagsStripped(t *testing.T) {
+	fruitTag := naming.TagWithPrefix("favfruit", options.DefaultTagPrefix)
+	fruitLabel := naming.LabelWithPrefix("favfruit", options.DefaultLabelPrefix)
+	envLabel := naming.LabelWithPrefix("env", options.DefaultLabelPrefix)
+	// written by earlier versions, which synced prefixed tags without stripping the prefix
+	staleLabel := naming.LabelWithPrefix(naming.TagWithPrefix("favveg", options.DefaultTagPrefix), options.DefaultLabelPrefix)
+
+	var strippedTest = []struct {
+		name     string
+		tags     map[string]*string
+		labels   map[string]string
+		expected map[string]*string
+	}{
+		{
+			"synced without the tag prefix",
+			map[string]*string{fruitTag: to.StringPtr("banana")},
+			map[string]string{},
+			map[string]*string{fruitLabel: to.StringPtr("banana")},
+		},
+		{
+			"not echoed to the node with the label",
+			map[string]*string{fruitTag: to.StringPtr("banana"), "env": to.StringPtr("test")},
+			map[string]string{"favfruit": "banana", fruitLabel: "banana", staleLabel: "broccoli"},
+			map[string]*string{envLabel: to.StringPtr("test"), fruitLabel: nil, staleLabel: nil},
+		},
+		{
+			"other tags take precedence",
+			map[string]*string{fruitTag: to.StringPtr("banana"), "favfruit": to.StringPtr("apple")},
+			map[string]string{},
+			map[string]*string{fruitLabel: to.StringPtr("apple")},
+		},
+	}
+
+	config := options.DefaultConfigOptions()
+	config.SyncDirection = options.TwoWay
+	log := ctrl.Log.WithName("node-label-operator-test")
+	for _, tt := range strippedTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node1", tt.labels)
+			recorder := record.NewFakeRecorder(10)
+			patch, err := TagsToNodes(defaultNamespacedName("node1"), azrsrc.NewFakeComputeResource(tt.tags), node, &config, log, recorder)
+			require.NoError(t, err)
+
+			spec := map[string]map[string]map[string]*string{}
+			require.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, tt.expected, spec["metadata"]["labels"])
+			assert.Empty(t, recorder.Events)
+		})
+	}
+}
+
+func TestSyncFilters(t *testing.T) {
+	tags := map[string]*string{
+		"env":                  to.StringPtr("test"),
+		"team":                 to.StringPtr("infra"),
+		"aks-managed-poolName": to.StringPtr("nodepool1"),
+		"creationSource":       to.StringPtr("aks-aks-nodepool1-vmss"),
+	}
+	node := NewFakeNode("node1", map[string]string{
+		"favfruit":                "banana",
+		"node.kubernetes.io/role": "agent",
+		"kubernetes.io/os":        "linux",
+	})
+	config := options.DefaultConfigOptions()
+	config.SyncDirection = options.TwoWay
+	config.TagFilter.Exclude = append(config.TagFilter.Exclude, naming.Pattern{Glob: "team"})
+	log := ctrl.Log.WithName("node-label-operator-test")
+	computeResource := azrsrc.NewFakeComputeResource(tags)
+
+	// platform tags and excluded tags aren't synced to nodes
+	patch, err := TagsToNodes(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	spec := map[string]map[string]map[string]*string{}
+	assert.NoError(t, json.Unmarshal(patch, &spec))
+	assert.Equal(t, map[string]*string{naming.LabelWithPrefix("env", options.DefaultLabelPrefix): to.StringPtr("test")},
+		spec["metadata"]["labels"])
+
+	// kubernetes labels aren't synced to tags
+	newTags, err := LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*string{naming.TagWithPrefix("favfruit", options.DefaultTagPrefix): to.StringPtr("banana")}, newTags)
+
+	// labels not matching an include pattern aren't synced to tags
+	config.LabelFilter.Include = []naming.Pattern{{Glob: "fav*"}}
+	node.Labels["env"] = "prod"
+	newTags, err = LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*string{naming.TagWithPrefix("favfruit", options.DefaultTagPrefix): to.StringPtr("banana")}, newTags)
+}
+
+func TestTagsAppliedToNodeAnnotations(t *testing.T) {
+	envLabel := naming.LabelWithPrefix("env", options.DefaultLabelPrefix)
+	descLabel := naming.LabelWithPrefix("description", options.DefaultLabelPrefix)
+	oldLabel := naming.LabelWithPrefix("old", options.DefaultLabelPrefix)
+	tags := map[string]*string{
+		"env":         to.StringPtr("test"),
+		"description": to.StringPtr("not a valid label value: spaces, colons and more"),
+	}
+
+	var annotationTest = []struct {
+		name                     string
+		target                   options.TagTarget
+		expectedPatchLabels      map[string]*string
+		expectedPatchAnnotations map[string]*string
+	}{
+		{
+			"annotations",
+			options.AnnotationTarget,
+			map[string]*string{envLabel: nil}, // label no longer synced
+			map[string]*string{envLabel: to.StringPtr("test"), descLabel: tags["description"], oldLabel: nil},
+		},
+		{
+			"both",
+			options.BothTargets,
+			nil,
+			map[string]*string{envLabel: to.StringPtr("test"), descLabel: tags["description"], oldLabel: nil},
+		},
+		{
+			"labels",
+			options.LabelTarget,
+			nil,
+			map[string]*string{oldLabel: nil}, // annotations no longer synced
+		},
+	}
+
+	for _, tt := range annotationTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node1", map[string]string{envLabel: "test"})
+			node.Annotations = map[string]string{oldLabel: "stale", "nodelabel.azure.com/sync-policy": "default"}
+			config := options.DefaultConfigOptions()
+			config.TagTarget = tt.target
+
+			log := ctrl.Log.WithName("node-label-operator-test")
+			patch, err := TagsToNodes(defaultNamespacedName("node1"), azrsrc.NewFakeComputeResource(tags), node, &config, log, record.NewFakeRecorder(0))
+			assert.NoError(t, err)
+
+			spec := map[string]map[string]map[string]*string{}
+			assert.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, tt.expectedPatchLabels, spec["metadata"]["labels"])
+			assert.Equal(t, tt.expectedPatchAnnotations, spec["metadata"]["annotations"])
+		})
+	}
+}
+
+func TestTransformedTagsRoundTrip(t *testing.T) {
+	tags := map[string]*string{
+		"Team Name": to.StringPtr("Hello World"),
+		"env":       to.StringPtr("test"),
+	}
+	config := options.DefaultConfigOptions()
+	config.SyncDirection = options.TwoWay
+	config.Transform = &naming.Transform{Lowercase: true, ReplaceInvalidChars: true, InvalidCharReplacement: "_"}
+	log := ctrl.Log.WithName("node-label-operator-test")
+	computeResource := azrsrc.NewFakeComputeResource(tags)
+	node := NewFakeNode("node1", map[string]string{})
+
+	patch, err := TagsToNodes(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	spec := map[string]map[string]map[string]string{}
+	assert.NoError(t, json.Unmarshal(patch, &spec))
+	teamLabel := naming.LabelWithPrefix("team_name", options.DefaultLabelPrefix)
+	assert.Equal(t, map[string]string{
+		teamLabel: "Hello_World",
+		naming.LabelWithPrefix("env", options.DefaultLabelPrefix): "test",
+	}, spec["metadata"]["labels"])
+
+	// labels created from tags don't change the original tags
+	node.Labels = spec["metadata"]["labels"]
+	newTags, err := LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	assert.Nil(t, newTags)
+
+	// and are no longer deleted as labels without tags
+	patch, err = TagsToNodes(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	assert.Nil(t, patch)
+
+	// a changed label value is written back to the original tag
+	config.ConflictPolicy = options.NodePrecedence
+	node.Labels[teamLabel] = "Platform"
+	newTags, err = LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &config, log, record.NewFakeRecorder(0))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*string{"Team Name": to.StringPtr("Platform")}, newTags)
+}
+
+func TestTagNameCollisions(t *testing.T) {
+	tags := map[string]*string{
+		"Team":  to.StringPtr("platform"),
+		"team":  to.StringPtr("infra"),
+		"owner": to.StringPtr("alice"),
+	}
+	teamLabel := naming.LabelWithPrefix("team", options.DefaultLabelPrefix)
+	ownerLabel := naming.LabelWithPrefix("owner", options.DefaultLabelPrefix)
+
+	var collisionTest = []struct {
+		policy         options.CollisionPolicy
+		existingLabels map[string]string
+		expectedLabels map[string]*string
+	}{
+		{
+			options.SkipCollisions,
+			map[string]string{teamLabel: "infra"},
+			map[string]*string{ownerLabel: to.StringPtr("alice"), teamLabel: nil},
+		},
+		{
+			options.FirstWins,
+			map[string]string{},
+			map[string]*string{ownerLabel: to.StringPtr("alice"), teamLabel: to.StringPtr("platform")},
+		},
+		{
+			options.HashCollisions,
+			map[string]string{},
+			map[string]*string{
+				ownerLabel: to.StringPtr("alice"),
+				naming.LabelWithPrefix(naming.HashTruncate("team", "Team", naming.MaxLabelNameLen), options.DefaultLabelPrefix): to.StringPtr("platform"),
+				naming.LabelWithPrefix(naming.HashTruncate("team", "team", naming.MaxLabelNameLen), options.DefaultLabelPrefix): to.StringPtr("infra"),
+			},
+		},
+	}
+
+	for _, tt := range collisionTest {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			config := options.DefaultConfigOptions()
+			config.Transform = &naming.Transform{Lowercase: true}
+			config.CollisionPolicy = tt.policy
+			node := NewFakeNode("node1", tt.existingLabels)
+			recorder := record.NewFakeRecorder(10)
+			log := ctrl.Log.WithName("node-label-operator-test")
+			computeResource := azrsrc.NewFakeComputeResource(tags)
+
+			patch, err := TagsToNodes(defaultNamespacedName("node1"), computeResource, node, &config, log, recorder)
+			assert.NoError(t, err)
+			spec := map[string]map[string]map[string]*string{}
+			assert.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, tt.expectedLabels, spec["metadata"]["labels"])
+			assert.Equal(t, 1, len(recorder.Events))
+
+			// labels map back to the tags they were created from
+			config.SyncDirection = options.TwoWay
+			for name, val := range spec["metadata"]["labels"] {
+				if val != nil {
+					node.Labels[name] = *val
+				}
+			}
+			newTags, err := LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &config, log, recorder)
+			assert.NoError(t, err)
+			assert.Nil(t, newTags)
+		})
+	}
+}
+
 func TestCorrectLabelsAppliedToAzureResources(t *testing.T) {
 	var nodeLabelsTest = []struct {
 		name         string
@@ -151,8 +403,8 @@ func TestCorrectLabelsAppliedToAzureResources(t *testing.T) {
 			},
 			map[string]*string{},
 			map[string]*string{
-				"favfruit": to.StringPtr("banana"),
-				"favveg":   to.StringPtr("broccoli"),
+				naming.TagWithPrefix("favfruit", options.DefaultTagPrefix): to.StringPtr("banana"),
+				naming.TagWithPrefix("favveg", options.DefaultTagPrefix):   to.StringPtr("broccoli"),
 			},
 		},
 		{
@@ -163,11 +415,11 @@ func TestCorrectLabelsAppliedToAzureResources(t *testing.T) {
 				"favanimal": "gopher",
 			},
 			map[string]*string{
-				"favanimal": to.StringPtr("gopher"),
+				naming.TagWithPrefix("favanimal", options.DefaultTagPrefix): to.StringPtr("gopher"),
 			},
 			map[string]*string{
-				"favfruit": to.StringPtr("banana"),
-				"favveg":   to.StringPtr("broccoli"),
+				naming.TagWithPrefix("favfruit", options.DefaultTagPrefix): to.StringPtr("banana"),
+				naming.TagWithPrefix("favveg", options.DefaultTagPrefix):   to.StringPtr("broccoli"),
 			},
 		},
 		{
@@ -180,7 +432,19 @@ func TestCorrectLabelsAppliedToAzureResources(t *testing.T) {
 				"favveg": to.StringPtr("broccoli"),
 			},
 			map[string]*string{
-				"favanimal": to.StringPtr("gopher"),
+				naming.TagWithPrefix("favanimal", options.DefaultTagPrefix): to.StringPtr("gopher"),
+			},
+		},
+		{
+			"resource4", // labels from tags aren't given the tag prefix
+			map[string]string{
+				naming.LabelWithPrefix("favveg", options.DefaultLabelPrefix): "broccoli",
+				"favanimal": "gopher",
+			},
+			map[string]*string{},
+			map[string]*string{
+				"favveg": to.StringPtr("broccoli"),
+				naming.TagWithPrefix("favanimal", options.DefaultTagPrefix): to.StringPtr("gopher"),
 			},
 		},
 	}
@@ -200,6 +464,7 @@ func TestCorrectLabelsAppliedToAzureResources(t *testing.T) {
 				t.Errorf("failed to apply labels to azure resources: %q", err)
 			}
 			assert.True(t, tags != nil) // should only be nil if no changes
+			assert.Equal(t, len(tt.expectedTags), len(tags))
 
 			fmt.Println(node.Labels)
 			fmt.Println(computeResource.Tags())
@@ -246,6 +511,18 @@ func TestLabelDeletionAllowed(t *testing.T) {
 	}
 }
 
+func TestDeleteLabelsWithPrefix(t *testing.T) {
+	node := NewFakeNode("node1", map[string]string{
+		"old.tags/env":     "test",
+		"old.tags/v":       "1",
+		"old.tags.io/keep": "true",
+		"azure.tags/env":   "test",
+	})
+	deleted := DeleteLabelsWithPrefix(node, "old.tags")
+	assert.Equal(t, map[string]*string{"old.tags/env": nil, "old.tags/v": nil}, deleted)
+	assert.Equal(t, map[string]string{"old.tags.io/keep": "true", "azure.tags/env": "test"}, node.Labels)
+}
+
 func NewFakeNode(name string, labels map[string]string) *corev1.Node {
 	node := &corev1.Node{}
 	node.Name = name
@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package naming
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// Pattern matches tag or label names, either as a glob or as a regular expression
+type Pattern struct {
+	Glob   string
+	Regexp *regexp.Regexp
+}
+
+func (p Pattern) Match(name string) bool {
+	if p.Regexp != nil {
+		return p.Regexp.MatchString(name)
+	}
+	ok, err := filepath.Match(p.Glob, name)
+	return err == nil && ok
+}
+
+// Filter selects which tags or labels are synced by name. A name is allowed if it matches none of the
+// Exclude patterns and, when there are Include patterns, at least one of them. A nil Filter allows every name.
+type Filter struct {
+	Include []Pattern
+	Exclude []Pattern
+}
+
+func (f *Filter) Allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if pattern.Match(name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if pattern.Match(name) {
+			return true
+		}
+	}
+	return false
+}
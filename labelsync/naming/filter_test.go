@@ -0,0 +1,45 @@
+package naming
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAllowed(t *testing.T) {
+	var filterTest = []struct {
+		name     string
+		filter   *Filter
+		expected map[string]bool
+	}{
+		{
+			"nil filter",
+			nil,
+			map[string]bool{"env": true, "aks-managed-poolName": true},
+		},
+		{
+			"glob exclude",
+			&Filter{Exclude: []Pattern{{Glob: "aks-managed-*"}, {Glob: "kubernetes.io/*"}}},
+			map[string]bool{"env": true, "aks-managed-poolName": false, "kubernetes.io/os": false, "beta.kubernetes.io/os": true},
+		},
+		{
+			"regexp include",
+			&Filter{Include: []Pattern{{Regexp: regexp.MustCompile("^(env|team)$")}}},
+			map[string]bool{"env": true, "team": true, "environment": false},
+		},
+		{
+			"exclude wins over include",
+			&Filter{Include: []Pattern{{Glob: "*"}}, Exclude: []Pattern{{Glob: "creationSource"}}},
+			map[string]bool{"env": true, "creationSource": false},
+		},
+	}
+
+	for _, tt := range filterTest {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, allowed := range tt.expected {
+				assert.Equal(t, allowed, tt.filter.Allowed(name), name)
+			}
+		})
+	}
+}
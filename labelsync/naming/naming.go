@@ -10,6 +10,7 @@ import (
 )
 
 const (
+	TagPrefixSep      string = "."
 	MaxTagNameLen     int    = 512
 	MaxTagValLen      int    = 256
 	MaxNumTags        int    = 50
@@ -32,6 +33,11 @@ func ValidTagVal(labelVal string) bool {
 	return len(labelVal) <= MaxTagValLen
 }
 
+// annotations keep the full tag value
+func ValidAnnotationVal(tagVal string) bool {
+	return len(tagVal) <= MaxTagValLen
+}
+
 func ValidLabelVal(tagVal string) bool {
 	if len(tagVal) > MaxLabelValLen {
 		return false
@@ -41,12 +47,7 @@ func ValidLabelVal(tagVal string) bool {
 }
 
 func ConvertTagNameToValidLabelName(tagName, labelPrefix string) string {
-	// lstrip configOptions.TagPrefix if there
-	// don't forget to get rid of '.' after 'node.labels'... are there prefixes here?
 	result := tagName
-	// if strings.HasPrefix(tagName, configOptions.TagPrefix) {
-	// 	result = strings.TrimPrefix(tagName, configOptions.TagPrefix)
-	// }
 
 	// truncate name segment to 63 characters or less
 	if len(result) > MaxLabelNameLen {
@@ -56,17 +57,13 @@ func ConvertTagNameToValidLabelName(tagName, labelPrefix string) string {
 	return LabelWithPrefix(result, labelPrefix)
 }
 
-func ConvertLabelNameToValidTagName(labelName, labelPrefix string) string {
-	// get rid of '/' and other characters.
-	// also detect if 'azure.tags/' or other prefix is in the name to get rid of it
-	// don't add if label name is a truncated version of a tag
-	result := labelName
-	if strings.HasPrefix(labelName, fmt.Sprintf("%s/", labelPrefix)) {
-		result = strings.TrimPrefix(labelName, fmt.Sprintf("%s/", labelPrefix))
+// labels with the label prefix were created from ARM tags, so they map back to the original tag name.
+// All other labels are written as tags with the tag prefix, so they can be told apart from other tags.
+func ConvertLabelNameToValidTagName(labelName, labelPrefix, tagPrefix string) string {
+	if HasLabelPrefix(labelName, labelPrefix) {
+		return LabelWithoutPrefix(labelName, labelPrefix)
 	}
-
-	// result = tagWithPrefix(result, configOptions.TagPrefix)
-	return result
+	return TagWithPrefix(labelName, tagPrefix)
 }
 
 func ConvertTagValToValidLabelVal(tagVal string) string {
@@ -99,6 +96,28 @@ func LabelWithoutPrefix(labelName, prefix string) string {
 	return labelName
 }
 
+func TagWithPrefix(tagName, prefix string) string {
+	if len(prefix) == 0 {
+		return tagName
+	}
+	return fmt.Sprintf("%s%s%s", prefix, TagPrefixSep, tagName)
+}
+
+func TagWithoutPrefix(tagName, prefix string) string {
+	if HasTagPrefix(tagName, prefix) {
+		return strings.TrimPrefix(tagName, prefix+TagPrefixSep)
+	}
+	return tagName
+}
+
+func HasTagPrefix(tagName, tagPrefix string) bool {
+	return len(tagPrefix) > 0 && strings.HasPrefix(tagName, tagPrefix+TagPrefixSep)
+}
+
+func ValidTagPrefix(tagPrefix string) bool {
+	return validTagName(tagPrefix) && len(tagPrefix) < MaxTagNameLen
+}
+
 func validTagName(labelName string) bool {
 	if len(labelName) > MaxTagNameLen {
 		return false
@@ -135,17 +135,20 @@ func TestConvertTagNameToValidLabelName(t *testing.T) {
 
 func TestConvertLabelNameToValidTagName(t *testing.T) {
 	var labelNameConversionTests = []struct {
-		given    string
-		expected string
+		given     string
+		tagPrefix string
+		expected  string
 	}{
-		{"favfruit", "favfruit"},        // have prefix?
-		{"azure.tags/favveg", "favveg"}, // have prefix?
+		{"favfruit", "", "favfruit"},
+		{"azure.tags/favveg", "", "favveg"},
+		{"favfruit", "node.labels", "node.labels.favfruit"},
+		{"azure.tags/favveg", "node.labels", "favveg"}, // came from a tag, so no tag prefix
 	}
 
 	labelPrefix := "azure.tags"
 	for _, tt := range labelNameConversionTests {
 		t.Run(tt.given, func(t *testing.T) {
-			validTagName := ConvertLabelNameToValidTagName(tt.given, labelPrefix)
+			validTagName := ConvertLabelNameToValidTagName(tt.given, labelPrefix, tt.tagPrefix)
 			if validTagName != tt.expected {
 				t.Errorf("given label name %q, got tag name %q, expected tag name %q", tt.given, validTagName, tt.expected)
 			}
@@ -153,6 +156,34 @@ func TestConvertLabelNameToValidTagName(t *testing.T) {
 	}
 }
 
+func TestTagPrefix(t *testing.T) {
+	var tagPrefixTests = []struct {
+		tagName   string
+		tagPrefix string
+		hasPrefix bool
+		stripped  string
+	}{
+		{"node.labels.favfruit", "node.labels", true, "favfruit"},
+		{"node.labelsfavfruit", "node.labels", false, "node.labelsfavfruit"},
+		{"favfruit", "node.labels", false, "favfruit"},
+		{"favfruit", "", false, "favfruit"},
+	}
+
+	for _, tt := range tagPrefixTests {
+		t.Run(tt.tagName, func(t *testing.T) {
+			if HasTagPrefix(tt.tagName, tt.tagPrefix) != tt.hasPrefix {
+				t.Errorf("given tag name %q and prefix %q, expected has prefix=%t", tt.tagName, tt.tagPrefix, tt.hasPrefix)
+			}
+			if stripped := TagWithoutPrefix(tt.tagName, tt.tagPrefix); stripped != tt.stripped {
+				t.Errorf("given tag name %q and prefix %q, got %q, expected %q", tt.tagName, tt.tagPrefix, stripped, tt.stripped)
+			}
+			if tt.hasPrefix && TagWithPrefix(tt.stripped, tt.tagPrefix) != tt.tagName {
+				t.Errorf("given tag name %q and prefix %q, prefix did not round trip", tt.tagName, tt.tagPrefix)
+			}
+		})
+	}
+}
+
 func TestConvertTagValToValidLabelVal(t *testing.T) {
 	var tagValConversionTests = []struct {
 		given    string
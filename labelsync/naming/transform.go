@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const hashSuffixLen int = 8
+
+var invalidLabelChars = regexp.MustCompile("[^-A-Za-z0-9_.]")
+var nonAlphanumEnds = regexp.MustCompile("^[^A-Za-z0-9]+|[^A-Za-z0-9]+$")
+
+// RenameRule replaces matches of Pattern in a tag name with Replacement, which can refer to
+// submatches as in regexp.ReplaceAllString
+type RenameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Transform is a pipeline of rules that change ARM tag names and values into valid label names and values.
+// Explicit mappings are applied first and skip the other rules. Otherwise names go through the renames,
+// lowercasing, invalid character replacement and truncation in that order, and values through the last two.
+// A nil Transform leaves names and values unchanged.
+type Transform struct {
+	NameMappings  map[string]string
+	ValueMappings map[string]string
+	Renames       []RenameRule
+	Lowercase     bool
+	// ReplaceInvalidChars enables replacing characters not allowed in labels with InvalidCharReplacement
+	ReplaceInvalidChars    bool
+	InvalidCharReplacement string
+	// HashTruncate shortens names and values over the label limit and appends a hash of the original
+	HashTruncate bool
+}
+
+func (t *Transform) TagNameToLabelName(tagName string) string {
+	if t == nil {
+		return tagName
+	}
+	if labelName, ok := t.NameMappings[tagName]; ok {
+		return labelName
+	}
+	result := tagName
+	for _, rule := range t.Renames {
+		result = rule.Pattern.ReplaceAllString(result, rule.Replacement)
+	}
+	if t.Lowercase {
+		result = strings.ToLower(result)
+	}
+	return t.fitLabel(result, tagName, MaxLabelNameLen)
+}
+
+func (t *Transform) TagValToLabelVal(tagVal string) string {
+	if t == nil {
+		return tagVal
+	}
+	if labelVal, ok := t.ValueMappings[tagVal]; ok {
+		return labelVal
+	}
+	return t.fitLabel(tagVal, tagVal, MaxLabelValLen)
+}
+
+// annotations can hold any tag value, so only explicit value mappings apply
+func (t *Transform) TagValToAnnotationVal(tagVal string) string {
+	if t == nil {
+		return tagVal
+	}
+	if val, ok := t.ValueMappings[tagVal]; ok {
+		return val
+	}
+	return tagVal
+}
+
+// LabelNameToTagName maps a label name back to a tag name for two-way sync. A tag on the resource that
+// transforms to the label name is preferred, then the reverse of the explicit name mappings.
+func (t *Transform) LabelNameToTagName(labelName string, tags map[string]*string) string {
+	if t == nil {
+		return labelName
+	}
+	var matches []string
+	for tagName := range tags {
+		if t.TagNameToLabelName(tagName) == labelName {
+			matches = append(matches, tagName)
+		}
+	}
+	if len(matches) > 0 {
+		sort.Strings(matches)
+		return matches[0]
+	}
+	if tagName, ok := reverseLookup(t.NameMappings, labelName); ok {
+		return tagName
+	}
+	return labelName
+}
+
+// LabelValToTagVal maps a label value back to a tag value for two-way sync. If the current tag value
+// transforms to the label value, it's kept so that the original tag isn't overwritten.
+func (t *Transform) LabelValToTagVal(labelVal string, tagVal *string) string {
+	if t == nil {
+		return labelVal
+	}
+	if tagVal != nil && t.TagValToLabelVal(*tagVal) == labelVal {
+		return *tagVal
+	}
+	if val, ok := reverseLookup(t.ValueMappings, labelVal); ok {
+		return val
+	}
+	return labelVal
+}
+
+func (t *Transform) fitLabel(s, original string, maxLen int) string {
+	if t.ReplaceInvalidChars {
+		s = invalidLabelChars.ReplaceAllString(s, t.InvalidCharReplacement)
+		s = nonAlphanumEnds.ReplaceAllString(s, "")
+	}
+	if t.HashTruncate && len(s) > maxLen {
+		s = HashTruncate(s, original, maxLen)
+	}
+	return s
+}
+
+// HashTruncate ends s with "-" and a hash of original, shortening it to fit in maxLen characters,
+// so that different originals with the same prefix stay distinct
+func HashTruncate(s, original string, maxLen int) string {
+	sum := sha256.Sum256([]byte(original))
+	suffix := hex.EncodeToString(sum[:])[:hashSuffixLen]
+	if len(s) > maxLen-hashSuffixLen-1 {
+		s = s[:maxLen-hashSuffixLen-1]
+	}
+	return strings.TrimRight(s, "-_.") + "-" + suffix
+}
+
+// deterministic when several keys map to the same value
+func reverseLookup(mappings map[string]string, val string) (string, bool) {
+	var keys []string
+	for k, v := range mappings {
+		if v == val {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return keys[0], true
+}
@@ -0,0 +1,97 @@
+package naming
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTransform() *Transform {
+	return &Transform{
+		NameMappings:  map[string]string{"CostCenter": "cost-center"},
+		ValueMappings: map[string]string{"Production": "prod"},
+		Renames: []RenameRule{
+			{Pattern: regexp.MustCompile("^aks-managed-(.*)$"), Replacement: "aks.$1"},
+		},
+		Lowercase:              true,
+		ReplaceInvalidChars:    true,
+		InvalidCharReplacement: "-",
+		HashTruncate:           true,
+	}
+}
+
+func TestTransformTagNameToLabelName(t *testing.T) {
+	longName := strings.Repeat("a", 70)
+	var transformTest = []struct {
+		tagName  string
+		expected string
+	}{
+		{"env", "env"},
+		{"CostCenter", "cost-center"},    // explicit mapping
+		{"aks-managed-pool", "aks.pool"}, // rename
+		{"Team Name", "team-name"},       // lowercase and invalid characters
+		{"(owner)", "owner"},             // invalid characters at the ends are removed
+		{longName, HashTruncate(longName, longName, MaxLabelNameLen)},
+	}
+
+	transform := newTestTransform()
+	for _, tt := range transformTest {
+		t.Run(tt.tagName, func(t *testing.T) {
+			labelName := transform.TagNameToLabelName(tt.tagName)
+			assert.Equal(t, tt.expected, labelName)
+			assert.True(t, len(labelName) <= MaxLabelNameLen)
+			assert.True(t, ValidLabelName(labelName))
+		})
+	}
+}
+
+func TestTransformTagValToLabelVal(t *testing.T) {
+	transform := newTestTransform()
+	assert.Equal(t, "prod", transform.TagValToLabelVal("Production"))
+	assert.Equal(t, "Hello-World", transform.TagValToLabelVal("Hello World!"))
+	assert.Equal(t, "prod", transform.TagValToAnnotationVal("Production"))
+	assert.Equal(t, "Hello World!", transform.TagValToAnnotationVal("Hello World!"))
+}
+
+func TestHashTruncate(t *testing.T) {
+	a := strings.Repeat("a", 60) + "-one"
+	b := strings.Repeat("a", 60) + "-two"
+	truncatedA := HashTruncate(a, a, MaxLabelValLen)
+	truncatedB := HashTruncate(b, b, MaxLabelValLen)
+	assert.Equal(t, MaxLabelValLen, len(truncatedA))
+	assert.NotEqual(t, truncatedA, truncatedB)
+	assert.True(t, ValidLabelVal(truncatedA))
+}
+
+func TestTransformReverse(t *testing.T) {
+	transform := newTestTransform()
+	tags := map[string]*string{
+		"Team Name":  to.StringPtr("Hello World!"),
+		"CostCenter": to.StringPtr("Production"),
+	}
+
+	// labels created from tags map back to the original tags
+	assert.Equal(t, "Team Name", transform.LabelNameToTagName("team-name", tags))
+	assert.Equal(t, "Hello World!", transform.LabelValToTagVal("Hello-World", tags["Team Name"]))
+	assert.Equal(t, "CostCenter", transform.LabelNameToTagName("cost-center", tags))
+	assert.Equal(t, "Production", transform.LabelValToTagVal("prod", tags["CostCenter"]))
+
+	// without a tag, explicit mappings are reversed
+	assert.Equal(t, "CostCenter", transform.LabelNameToTagName("cost-center", nil))
+	assert.Equal(t, "Production", transform.LabelValToTagVal("prod", nil))
+
+	// changed label value is written back as is
+	assert.Equal(t, "Goodbye", transform.LabelValToTagVal("Goodbye", tags["Team Name"]))
+	assert.Equal(t, "new-label", transform.LabelNameToTagName("new-label", tags))
+}
+
+func TestNilTransform(t *testing.T) {
+	var transform *Transform
+	assert.Equal(t, "Team Name", transform.TagNameToLabelName("Team Name"))
+	assert.Equal(t, "Hello World!", transform.TagValToLabelVal("Hello World!"))
+	assert.Equal(t, "env", transform.LabelNameToTagName("env", nil))
+	assert.Equal(t, "test", transform.LabelValToTagVal("test", to.StringPtr("other")))
+}
@@ -7,11 +7,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 
+	"github.com/Azure/node-label-operator/api/v1alpha1"
 	"github.com/Azure/node-label-operator/labelsync/naming"
 )
 
@@ -39,13 +42,66 @@ const (
 	NodePrecedence ConflictPolicy = "node-precedence"
 )
 
+type TagTarget string
+
+const (
+	LabelTarget      TagTarget = "labels"
+	AnnotationTarget TagTarget = "annotations"
+	BothTargets      TagTarget = "both"
+)
+
+type LabelAggregation string
+
+const (
+	// write a label only if every node on the VMSS has it with the same value
+	Unanimous LabelAggregation = "unanimous"
+	// write a label if more than half of the nodes on the VMSS have it with the same value
+	Majority LabelAggregation = "majority"
+	// write a label if any node on the VMSS has it, unless nodes have different values for it
+	Any LabelAggregation = "any"
+	// write only the labels of the leader node, the first node on the VMSS by name
+	Leader LabelAggregation = "leader"
+)
+
+type InstanceTags string
+
+const (
+	IgnoreInstanceTags InstanceTags = "ignore"
+	// instance tags override scale set tags with the same name
+	InstancePrecedence InstanceTags = "instance-precedence"
+	// scale set tags override instance tags with the same name
+	ScaleSetPrecedence InstanceTags = "scale-set-precedence"
+)
+
+type CollisionPolicy string
+
+const (
+	// sync none of the tags that map to the same label name
+	SkipCollisions CollisionPolicy = "skip"
+	// end each label name with a hash of its tag name
+	HashCollisions CollisionPolicy = "hash"
+	// sync only the first tag by name
+	FirstWins CollisionPolicy = "first"
+)
+
 type ConfigOptions struct {
-	SyncDirection       SyncDirection  `json:"syncDirection"`
-	LabelPrefix         string         `json:"labelPrefix"`
-	TagPrefix           string         `json:"tagPrefix"`
-	ConflictPolicy      ConflictPolicy `json:"conflictPolicy"`
-	ResourceGroupFilter string         `json:"resourceGroupFilter"`
-	MinSyncPeriod       string         `json:"minSyncPeriod"`
+	SyncDirection       SyncDirection    `json:"syncDirection"`
+	LabelPrefix         string           `json:"labelPrefix"`
+	TagPrefix           string           `json:"tagPrefix"`
+	ConflictPolicy      ConflictPolicy   `json:"conflictPolicy"`
+	TagTarget           TagTarget        `json:"tagTarget"`
+	LabelAggregation    LabelAggregation `json:"labelAggregation"`
+	InstanceTags        InstanceTags     `json:"instanceTags"`
+	PlatformLabelPrefix string           `json:"platformLabelPrefix"`
+	CollisionPolicy     CollisionPolicy  `json:"collisionPolicy"`
+	ResourceGroupFilter string           `json:"resourceGroupFilter"`
+	MinSyncPeriod       string           `json:"minSyncPeriod"`
+	// taint mappings, tag transforms and filters can't be set in the legacy options ConfigMap
+	Taints    []v1alpha1.TagTaint `json:"-"`
+	Transform *naming.Transform   `json:"-"`
+	// TagFilter selects the tags synced to nodes, LabelFilter the labels synced to ARM tags
+	TagFilter   *naming.Filter `json:"-"`
+	LabelFilter *naming.Filter `json:"-"`
 }
 
 func NewConfig(configMap corev1.ConfigMap) (*ConfigOptions, error) {
@@ -54,44 +110,126 @@ func NewConfig(configMap corev1.ConfigMap) (*ConfigOptions, error) {
 		return nil, err
 	}
 
-	if configOptions.SyncDirection == "" {
-		configOptions.SyncDirection = ARMToNode
-	} else if configOptions.SyncDirection != TwoWay &&
-		configOptions.SyncDirection != ARMToNode &&
-		configOptions.SyncDirection != NodeToARM {
-		return nil, errors.New("invalid sync direction")
+	if err := configOptions.setDefaultsAndValidate(); err != nil {
+		return nil, err
 	}
 
-	if configOptions.LabelPrefix == UNSET {
-		configOptions.LabelPrefix = DefaultLabelPrefix
-	} else if len(configOptions.LabelPrefix) > naming.MaxLabelPrefixLen {
-		return nil, fmt.Errorf(fmt.Sprintf("label prefix is over %d characters", naming.MaxLabelPrefixLen))
+	return &configOptions, nil
+}
+
+// fill in unset options with defaults, and return an error for any invalid option
+func (c *ConfigOptions) setDefaultsAndValidate() error {
+	if c.SyncDirection == "" {
+		c.SyncDirection = ARMToNode
+	} else if c.SyncDirection != TwoWay &&
+		c.SyncDirection != ARMToNode &&
+		c.SyncDirection != NodeToARM {
+		return fmt.Errorf("invalid sync direction %q, must be one of %s, %s or %s", c.SyncDirection, ARMToNode, NodeToARM, TwoWay)
 	}
 
-	// also validate prefix?
-	if configOptions.TagPrefix == UNSET {
-		configOptions.TagPrefix = DefaultTagPrefix
+	if c.LabelPrefix == UNSET {
+		c.LabelPrefix = DefaultLabelPrefix
+	} else if len(c.LabelPrefix) > naming.MaxLabelPrefixLen {
+		return fmt.Errorf("label prefix is over %d characters", naming.MaxLabelPrefixLen)
 	}
 
-	if configOptions.ConflictPolicy == "" {
-		configOptions.ConflictPolicy = ARMPrecedence
-	} else if configOptions.ConflictPolicy != Ignore &&
-		configOptions.ConflictPolicy != ARMPrecedence &&
-		configOptions.ConflictPolicy != NodePrecedence {
-		return nil, errors.New("invalid tag-to-label conflict policy")
+	if c.TagPrefix == UNSET {
+		c.TagPrefix = DefaultTagPrefix
+	} else if !naming.ValidTagPrefix(c.TagPrefix) {
+		return fmt.Errorf("invalid tag prefix %q, must be under %d characters and not contain any of %q",
+			c.TagPrefix, naming.MaxTagNameLen, naming.InvalidTagChars)
 	}
 
-	if configOptions.ResourceGroupFilter == "" {
-		configOptions.ResourceGroupFilter = DefaultResourceGroupFilter
+	if c.ConflictPolicy == "" {
+		c.ConflictPolicy = ARMPrecedence
+	} else if c.ConflictPolicy != Ignore &&
+		c.ConflictPolicy != ARMPrecedence &&
+		c.ConflictPolicy != NodePrecedence {
+		return fmt.Errorf("invalid tag-to-label conflict policy %q, must be one of %s, %s or %s", c.ConflictPolicy, ARMPrecedence, NodePrecedence, Ignore)
 	}
 
-	if configOptions.MinSyncPeriod == "" {
-		configOptions.MinSyncPeriod = DefaultMinSyncPeriod
-	} else if _, err = time.ParseDuration(configOptions.MinSyncPeriod); err != nil {
-		return nil, err
+	if c.TagTarget == "" {
+		c.TagTarget = LabelTarget
+	} else if c.TagTarget != LabelTarget &&
+		c.TagTarget != AnnotationTarget &&
+		c.TagTarget != BothTargets {
+		return fmt.Errorf("invalid tag target %q, must be one of %s, %s or %s", c.TagTarget, LabelTarget, AnnotationTarget, BothTargets)
 	}
 
-	return &configOptions, nil
+	if c.LabelAggregation == "" {
+		c.LabelAggregation = Any
+	} else if c.LabelAggregation != Unanimous &&
+		c.LabelAggregation != Majority &&
+		c.LabelAggregation != Any &&
+		c.LabelAggregation != Leader {
+		return fmt.Errorf("invalid label aggregation %q, must be one of %s, %s, %s or %s", c.LabelAggregation, Unanimous, Majority, Any, Leader)
+	}
+
+	if c.InstanceTags == "" {
+		c.InstanceTags = IgnoreInstanceTags
+	} else if c.InstanceTags != IgnoreInstanceTags &&
+		c.InstanceTags != InstancePrecedence &&
+		c.InstanceTags != ScaleSetPrecedence {
+		return fmt.Errorf("invalid instance tags option %q, must be one of %s, %s or %s", c.InstanceTags, IgnoreInstanceTags, InstancePrecedence, ScaleSetPrecedence)
+	}
+
+	if len(c.PlatformLabelPrefix) > naming.MaxLabelPrefixLen {
+		return fmt.Errorf("platform label prefix is over %d characters", naming.MaxLabelPrefixLen)
+	} else if c.PlatformLabelPrefix != "" && c.PlatformLabelPrefix == c.LabelPrefix {
+		return fmt.Errorf("platform label prefix %q must be different from the label prefix", c.PlatformLabelPrefix)
+	}
+
+	if c.CollisionPolicy == "" {
+		c.CollisionPolicy = SkipCollisions
+	} else if c.CollisionPolicy != SkipCollisions &&
+		c.CollisionPolicy != HashCollisions &&
+		c.CollisionPolicy != FirstWins {
+		return fmt.Errorf("invalid collision policy %q, must be one of %s, %s or %s", c.CollisionPolicy, SkipCollisions, HashCollisions, FirstWins)
+	}
+
+	if c.ResourceGroupFilter == "" {
+		c.ResourceGroupFilter = DefaultResourceGroupFilter
+	}
+
+	if c.MinSyncPeriod == "" {
+		c.MinSyncPeriod = DefaultMinSyncPeriod
+	} else if _, err := time.ParseDuration(c.MinSyncPeriod); err != nil {
+		return fmt.Errorf("invalid min sync period: %v", err)
+	}
+
+	if c.TagFilter == nil {
+		c.TagFilter = DefaultTagFilter()
+	}
+	if c.LabelFilter == nil {
+		c.LabelFilter = DefaultLabelFilter()
+	}
+
+	for i, taint := range c.Taints {
+		if err := validateTagTaint(taint); err != nil {
+			return fmt.Errorf("invalid taint mapping %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func validateTagTaint(taint v1alpha1.TagTaint) error {
+	if taint.TagName == "" {
+		return errors.New("tag name must be set")
+	}
+	if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid taint key %q: %s", taint.Key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+		return fmt.Errorf("invalid taint value %q: %s", taint.Value, strings.Join(errs, ", "))
+	}
+	if taint.Effect != corev1.TaintEffectNoSchedule &&
+		taint.Effect != corev1.TaintEffectPreferNoSchedule &&
+		taint.Effect != corev1.TaintEffectNoExecute {
+		return fmt.Errorf("invalid taint effect %q, must be one of %s, %s or %s", taint.Effect,
+			corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute)
+	}
+	return nil
 }
 
 func NewDefaultConfig() (*corev1.ConfigMap, error) {
@@ -109,8 +247,14 @@ func DefaultConfigOptions() ConfigOptions {
 		LabelPrefix:         DefaultLabelPrefix,
 		TagPrefix:           DefaultTagPrefix,
 		ConflictPolicy:      ARMPrecedence,
+		TagTarget:           LabelTarget,
+		LabelAggregation:    Any,
+		InstanceTags:        IgnoreInstanceTags,
+		CollisionPolicy:     SkipCollisions,
 		ResourceGroupFilter: DefaultResourceGroupFilter,
 		MinSyncPeriod:       DefaultMinSyncPeriod,
+		TagFilter:           DefaultTagFilter(),
+		LabelFilter:         DefaultLabelFilter(),
 	}
 }
 
@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+)
+
+type PatternSyntax string
+
+const (
+	GlobSyntax  PatternSyntax = "glob"
+	RegexSyntax PatternSyntax = "regex"
+)
+
+var (
+	// tags written by AKS and the Azure platform
+	DefaultTagExcludes = []string{"aks-managed-*", "creationSource"}
+	// labels set by Kubernetes and the Azure cloud provider
+	DefaultLabelExcludes = []string{"kubernetes.io/*", "node.kubernetes.io/*", "*.kubernetes.io/*", "kubernetes.azure.com/*"}
+)
+
+func DefaultTagFilter() *naming.Filter {
+	return &naming.Filter{Exclude: globPatterns(DefaultTagExcludes)}
+}
+
+func DefaultLabelFilter() *naming.Filter {
+	return &naming.Filter{Exclude: globPatterns(DefaultLabelExcludes)}
+}
+
+// SyncFilter -> naming.Filter, compiling patterns and adding the default excludes unless
+// platform names are synced. Returns a filter with only the default excludes if spec is nil.
+func NewFilter(spec *v1alpha1.SyncFilter, defaultExcludes []string) (*naming.Filter, error) {
+	if spec == nil {
+		return &naming.Filter{Exclude: globPatterns(defaultExcludes)}, nil
+	}
+	syntax := PatternSyntax(spec.PatternSyntax)
+	if syntax == "" {
+		syntax = GlobSyntax
+	} else if syntax != GlobSyntax && syntax != RegexSyntax {
+		return nil, fmt.Errorf("invalid pattern syntax %q, must be one of %s or %s", syntax, GlobSyntax, RegexSyntax)
+	}
+
+	filter := &naming.Filter{}
+	if !spec.SyncPlatformNames {
+		filter.Exclude = globPatterns(defaultExcludes)
+	}
+	for _, include := range spec.Include {
+		pattern, err := newPattern(include, syntax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %v", include, err)
+		}
+		filter.Include = append(filter.Include, pattern)
+	}
+	for _, exclude := range spec.Exclude {
+		pattern, err := newPattern(exclude, syntax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", exclude, err)
+		}
+		filter.Exclude = append(filter.Exclude, pattern)
+	}
+	return filter, nil
+}
+
+func newPattern(pattern string, syntax PatternSyntax) (naming.Pattern, error) {
+	if syntax == RegexSyntax {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return naming.Pattern{}, err
+		}
+		return naming.Pattern{Regexp: re}, nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return naming.Pattern{}, err
+	}
+	return naming.Pattern{Glob: pattern}, nil
+}
+
+func globPatterns(globs []string) []naming.Pattern {
+	patterns := []naming.Pattern{}
+	for _, glob := range globs {
+		patterns = append(patterns, naming.Pattern{Glob: glob})
+	}
+	return patterns
+}
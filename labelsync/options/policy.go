@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+)
+
+const (
+	DefaultPolicyName string = "default"
+	// annotation set on a policy created from the legacy options ConfigMap
+	MigratedFromAnnotation string = "nodelabel.azure.com/migrated-from"
+	// node annotation naming the sync policy last applied to the node
+	PolicyAnnotation string = "nodelabel.azure.com/sync-policy"
+	// node annotation with the label prefix last applied to the node, so labels can be
+	// cleaned up when the prefix changes
+	LabelPrefixAnnotation string = "nodelabel.azure.com/label-prefix"
+	// node annotation with the platform label prefix last applied to the node, so platform labels can be
+	// cleaned up when the prefix changes or platform labels are disabled
+	PlatformLabelPrefixAnnotation string = "nodelabel.azure.com/platform-label-prefix"
+)
+
+// NodeLabelSyncPolicy -> ConfigOptions, with defaults applied and options validated
+func NewConfigFromPolicy(policy *v1alpha1.NodeLabelSyncPolicy) (*ConfigOptions, error) {
+	if err := validatePolicySelectors(&policy.Spec); err != nil {
+		return nil, err
+	}
+	configOptions := LoadConfigOptionsFromPolicySpec(&policy.Spec)
+	tagFilter, err := NewFilter(policy.Spec.TagFilter, DefaultTagExcludes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag filter: %v", err)
+	}
+	configOptions.TagFilter = tagFilter
+	labelFilter, err := NewFilter(policy.Spec.LabelFilter, DefaultLabelExcludes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label filter: %v", err)
+	}
+	configOptions.LabelFilter = labelFilter
+	if err := configOptions.setDefaultsAndValidate(); err != nil {
+		return nil, err
+	}
+	transform, err := NewTransform(policy.Spec.Transform)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %v", err)
+	}
+	configOptions.Transform = transform
+	return &configOptions, nil
+}
+
+// ValidatePolicy returns an error describing the first invalid setting in the policy, if any
+func ValidatePolicy(policy *v1alpha1.NodeLabelSyncPolicy) error {
+	_, err := NewConfigFromPolicy(policy)
+	return err
+}
+
+// NodeLabelSyncPolicySpec -> ConfigOptions, without defaulting or validation
+func LoadConfigOptionsFromPolicySpec(spec *v1alpha1.NodeLabelSyncPolicySpec) ConfigOptions {
+	configOptions := ConfigOptions{
+		SyncDirection:       SyncDirection(spec.SyncDirection),
+		LabelPrefix:         UNSET,
+		TagPrefix:           UNSET,
+		ConflictPolicy:      ConflictPolicy(spec.ConflictPolicy),
+		TagTarget:           TagTarget(spec.TagTarget),
+		LabelAggregation:    LabelAggregation(spec.LabelAggregation),
+		InstanceTags:        InstanceTags(spec.InstanceTags),
+		PlatformLabelPrefix: spec.PlatformLabelPrefix,
+		CollisionPolicy:     CollisionPolicy(spec.CollisionPolicy),
+		ResourceGroupFilter: spec.ResourceGroupFilter,
+		MinSyncPeriod:       spec.MinSyncPeriod,
+		Taints:              spec.Taints,
+	}
+	if spec.LabelPrefix != nil {
+		configOptions.LabelPrefix = *spec.LabelPrefix
+	}
+	if spec.TagPrefix != nil {
+		configOptions.TagPrefix = *spec.TagPrefix
+	}
+	return configOptions
+}
+
+// ConfigOptions -> NodeLabelSyncPolicySpec
+func GetPolicySpecFromConfigOptions(configOptions *ConfigOptions) v1alpha1.NodeLabelSyncPolicySpec {
+	return v1alpha1.NodeLabelSyncPolicySpec{
+		SyncDirection:       string(configOptions.SyncDirection),
+		LabelPrefix:         to.StringPtr(configOptions.LabelPrefix),
+		TagPrefix:           to.StringPtr(configOptions.TagPrefix),
+		ConflictPolicy:      string(configOptions.ConflictPolicy),
+		TagTarget:           string(configOptions.TagTarget),
+		LabelAggregation:    string(configOptions.LabelAggregation),
+		InstanceTags:        string(configOptions.InstanceTags),
+		PlatformLabelPrefix: configOptions.PlatformLabelPrefix,
+		CollisionPolicy:     string(configOptions.CollisionPolicy),
+		ResourceGroupFilter: configOptions.ResourceGroupFilter,
+		MinSyncPeriod:       configOptions.MinSyncPeriod,
+		Taints:              configOptions.Taints,
+	}
+}
+
+// SetPolicyDefaults fills in every unset field of the spec with its default value
+func SetPolicyDefaults(spec *v1alpha1.NodeLabelSyncPolicySpec) {
+	defaults := DefaultConfigOptions()
+	if spec.SyncDirection == "" {
+		spec.SyncDirection = string(defaults.SyncDirection)
+	}
+	if spec.LabelPrefix == nil {
+		spec.LabelPrefix = to.StringPtr(defaults.LabelPrefix)
+	}
+	if spec.TagPrefix == nil {
+		spec.TagPrefix = to.StringPtr(defaults.TagPrefix)
+	}
+	if spec.ConflictPolicy == "" {
+		spec.ConflictPolicy = string(defaults.ConflictPolicy)
+	}
+	if spec.TagTarget == "" {
+		spec.TagTarget = string(defaults.TagTarget)
+	}
+	if spec.LabelAggregation == "" {
+		spec.LabelAggregation = string(defaults.LabelAggregation)
+	}
+	if spec.InstanceTags == "" {
+		spec.InstanceTags = string(defaults.InstanceTags)
+	}
+	if spec.CollisionPolicy == "" {
+		spec.CollisionPolicy = string(defaults.CollisionPolicy)
+	}
+	if spec.ResourceGroupFilter == "" {
+		spec.ResourceGroupFilter = defaults.ResourceGroupFilter
+	}
+	if spec.MinSyncPeriod == "" {
+		spec.MinSyncPeriod = defaults.MinSyncPeriod
+	}
+}
+
+func NewDefaultPolicy() *v1alpha1.NodeLabelSyncPolicy {
+	configOptions := DefaultConfigOptions()
+	return newPolicy(GetPolicySpecFromConfigOptions(&configOptions))
+}
+
+// NewPolicyFromConfigMap converts the legacy options ConfigMap into an equivalent policy
+func NewPolicyFromConfigMap(configMap corev1.ConfigMap) (*v1alpha1.NodeLabelSyncPolicy, error) {
+	configOptions, err := NewConfig(configMap)
+	if err != nil {
+		return nil, err
+	}
+	policy := newPolicy(GetPolicySpecFromConfigOptions(configOptions))
+	policy.Annotations = map[string]string{
+		MigratedFromAnnotation: fmt.Sprintf("configmap/%s/%s", configMap.Namespace, configMap.Name),
+	}
+	return policy, nil
+}
+
+func PolicyNamespacedName() types.NamespacedName {
+	return types.NamespacedName{Name: DefaultPolicyName}
+}
+
+func newPolicy(spec v1alpha1.NodeLabelSyncPolicySpec) *v1alpha1.NodeLabelSyncPolicy {
+	policy := &v1alpha1.NodeLabelSyncPolicy{Spec: spec}
+	policy.Name = PolicyNamespacedName().Name
+	return policy
+}
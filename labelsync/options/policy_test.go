@@ -0,0 +1,203 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+)
+
+func TestNewConfigFromPolicy(t *testing.T) {
+	var policyTests = []struct {
+		name          string
+		spec          v1alpha1.NodeLabelSyncPolicySpec
+		expectSuccess bool
+		expected      ConfigOptions
+	}{
+		{
+			"empty spec",
+			v1alpha1.NodeLabelSyncPolicySpec{},
+			true,
+			DefaultConfigOptions(),
+		},
+		{
+			"empty label prefix",
+			v1alpha1.NodeLabelSyncPolicySpec{SyncDirection: "two-way", LabelPrefix: to.StringPtr("")},
+			true,
+			ConfigOptions{
+				SyncDirection:       TwoWay,
+				LabelPrefix:         "",
+				TagPrefix:           DefaultTagPrefix,
+				ConflictPolicy:      ARMPrecedence,
+				TagTarget:           LabelTarget,
+				LabelAggregation:    Any,
+				InstanceTags:        IgnoreInstanceTags,
+				CollisionPolicy:     SkipCollisions,
+				ResourceGroupFilter: DefaultResourceGroupFilter,
+				MinSyncPeriod:       DefaultMinSyncPeriod,
+				TagFilter:           DefaultTagFilter(),
+				LabelFilter:         DefaultLabelFilter(),
+			},
+		},
+		{
+			"invalid sync direction",
+			v1alpha1.NodeLabelSyncPolicySpec{SyncDirection: "sideways"},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid tag prefix",
+			v1alpha1.NodeLabelSyncPolicySpec{TagPrefix: to.StringPtr("node/labels")},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid tag target",
+			v1alpha1.NodeLabelSyncPolicySpec{TagTarget: "taints"},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid label aggregation",
+			v1alpha1.NodeLabelSyncPolicySpec{LabelAggregation: "plurality"},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid instance tags option",
+			v1alpha1.NodeLabelSyncPolicySpec{InstanceTags: "instance"},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"platform label prefix same as label prefix",
+			v1alpha1.NodeLabelSyncPolicySpec{PlatformLabelPrefix: DefaultLabelPrefix},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"taint mapping",
+			v1alpha1.NodeLabelSyncPolicySpec{Taints: []v1alpha1.TagTaint{{TagName: "workload", TagValue: "gpu", Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}}},
+			true,
+			ConfigOptions{
+				SyncDirection:       ARMToNode,
+				LabelPrefix:         DefaultLabelPrefix,
+				TagPrefix:           DefaultTagPrefix,
+				ConflictPolicy:      ARMPrecedence,
+				TagTarget:           LabelTarget,
+				LabelAggregation:    Any,
+				InstanceTags:        IgnoreInstanceTags,
+				CollisionPolicy:     SkipCollisions,
+				ResourceGroupFilter: DefaultResourceGroupFilter,
+				MinSyncPeriod:       DefaultMinSyncPeriod,
+				TagFilter:           DefaultTagFilter(),
+				LabelFilter:         DefaultLabelFilter(),
+				Taints:              []v1alpha1.TagTaint{{TagName: "workload", TagValue: "gpu", Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}},
+			},
+		},
+		{
+			"invalid taint effect",
+			v1alpha1.NodeLabelSyncPolicySpec{Taints: []v1alpha1.TagTaint{{TagName: "workload", Key: "dedicated", Effect: "NoRun"}}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid taint key",
+			v1alpha1.NodeLabelSyncPolicySpec{Taints: []v1alpha1.TagTaint{{TagName: "workload", Key: "dedicated workload", Effect: "NoSchedule"}}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid rename pattern",
+			v1alpha1.NodeLabelSyncPolicySpec{Transform: &v1alpha1.TagTransform{Renames: []v1alpha1.RenameRule{{Pattern: "(", Replacement: ""}}}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid character replacement",
+			v1alpha1.NodeLabelSyncPolicySpec{Transform: &v1alpha1.TagTransform{InvalidCharReplacement: to.StringPtr("/")}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid collision policy",
+			v1alpha1.NodeLabelSyncPolicySpec{CollisionPolicy: "last"},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid tag filter pattern",
+			v1alpha1.NodeLabelSyncPolicySpec{TagFilter: &v1alpha1.SyncFilter{Include: []string{"env["}}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid label filter syntax",
+			v1alpha1.NodeLabelSyncPolicySpec{LabelFilter: &v1alpha1.SyncFilter{Exclude: []string{"^env$"}, PatternSyntax: "re2"}},
+			false,
+			ConfigOptions{},
+		},
+		{
+			"invalid min sync period",
+			v1alpha1.NodeLabelSyncPolicySpec{MinSyncPeriod: "5 minutes"},
+			false,
+			ConfigOptions{},
+		},
+	}
+
+	for _, tt := range policyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v1alpha1.NodeLabelSyncPolicy{Spec: tt.spec}
+			configOptions, err := NewConfigFromPolicy(policy)
+			if err != nil {
+				if tt.expectSuccess {
+					t.Errorf("failed to load config options from policy: %q", err)
+				}
+				return
+			}
+			if !tt.expectSuccess {
+				t.Errorf("expected invalid policy to fail")
+			}
+			assert.Equal(t, tt.expected, *configOptions)
+		})
+	}
+}
+
+func TestNewPolicyFromConfigMap(t *testing.T) {
+	configMap := NewFakeConfigMap()
+	configMap.Name = ConfigMapNamespacedName().Name
+	configMap.Namespace = ConfigMapNamespacedName().Namespace
+	policy, err := NewPolicyFromConfigMap(*configMap)
+	if err != nil {
+		t.Errorf("failed to migrate config map: %q", err)
+	}
+	assert.Equal(t, DefaultPolicyName, policy.Name)
+	assert.Equal(t, "two-way", policy.Spec.SyncDirection)
+	assert.Equal(t, "", *policy.Spec.LabelPrefix)
+	assert.Equal(t, DefaultTagPrefix, *policy.Spec.TagPrefix)
+	assert.Equal(t, "1m", policy.Spec.MinSyncPeriod)
+	assert.Equal(t, "configmap/node-label-operator-system/node-label-operator", policy.Annotations[MigratedFromAnnotation])
+
+	// round trip back to the same options
+	fromConfigMap, err := NewConfig(*configMap)
+	assert.NoError(t, err)
+	fromPolicy, err := NewConfigFromPolicy(policy)
+	assert.NoError(t, err)
+	assert.Equal(t, fromConfigMap, fromPolicy)
+}
+
+func TestSetPolicyDefaults(t *testing.T) {
+	spec := v1alpha1.NodeLabelSyncPolicySpec{ConflictPolicy: "ignore", LabelPrefix: to.StringPtr("")}
+	SetPolicyDefaults(&spec)
+	assert.Equal(t, string(ARMToNode), spec.SyncDirection)
+	assert.Equal(t, "", *spec.LabelPrefix)
+	assert.Equal(t, DefaultTagPrefix, *spec.TagPrefix)
+	assert.Equal(t, string(Ignore), spec.ConflictPolicy)
+	assert.Equal(t, DefaultResourceGroupFilter, spec.ResourceGroupFilter)
+	assert.Equal(t, DefaultMinSyncPeriod, spec.MinSyncPeriod)
+}
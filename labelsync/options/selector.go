@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+)
+
+// InvalidPolicy is a policy skipped by SelectPolicy, with the reason it can't be matched
+type InvalidPolicy struct {
+	Policy *v1alpha1.NodeLabelSyncPolicy
+	Err    error
+}
+
+// SelectPolicy returns the policy that applies to a node running on the named VMSS or VM,
+// or nil if no policy matches. When several policies match, the one with the highest
+// priority is chosen, and ties are broken by policy name. Policies with an invalid node
+// selector or resource name pattern are skipped, and returned as invalid, so they don't
+// keep the other policies from applying.
+func SelectPolicy(policies []v1alpha1.NodeLabelSyncPolicy, node *corev1.Node, resourceName string) (
+	*v1alpha1.NodeLabelSyncPolicy, []InvalidPolicy) {
+
+	sorted := make([]v1alpha1.NodeLabelSyncPolicy, len(policies))
+	copy(sorted, policies)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Spec.Priority != sorted[j].Spec.Priority {
+			return sorted[i].Spec.Priority > sorted[j].Spec.Priority
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var invalid []InvalidPolicy
+	for i := range sorted {
+		ok, err := PolicyMatches(&sorted[i], node, resourceName)
+		if err != nil {
+			invalid = append(invalid, InvalidPolicy{Policy: &sorted[i], Err: err})
+			continue
+		}
+		if ok {
+			return &sorted[i], invalid
+		}
+	}
+	return nil, invalid
+}
+
+// PolicyMatches returns true if both the node selector and resource name pattern
+// of the policy, when set, match the node
+func PolicyMatches(policy *v1alpha1.NodeLabelSyncPolicy, node *corev1.Node, resourceName string) (bool, error) {
+	if policy.Spec.NodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NodeSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid node selector in policy %s: %v", policy.Name, err)
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			return false, nil
+		}
+	}
+	if policy.Spec.ResourceNamePattern != "" {
+		ok, err := filepath.Match(policy.Spec.ResourceNamePattern, resourceName)
+		if err != nil {
+			return false, fmt.Errorf("invalid resource name pattern in policy %s: %v", policy.Name, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func validatePolicySelectors(spec *v1alpha1.NodeLabelSyncPolicySpec) error {
+	if spec.NodeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.NodeSelector); err != nil {
+			return fmt.Errorf("invalid node selector: %v", err)
+		}
+	}
+	if _, err := filepath.Match(spec.ResourceNamePattern, ""); err != nil {
+		return fmt.Errorf("invalid resource name pattern: %v", err)
+	}
+	return nil
+}
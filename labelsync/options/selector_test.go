@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+)
+
+func TestSelectPolicy(t *testing.T) {
+	policies := []v1alpha1.NodeLabelSyncPolicy{
+		newFakePolicy("default", v1alpha1.NodeLabelSyncPolicySpec{}),
+		newFakePolicy("gpu", v1alpha1.NodeLabelSyncPolicySpec{
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"accelerator": "nvidia"}},
+			Priority:     10,
+		}),
+		newFakePolicy("gpu-pool", v1alpha1.NodeLabelSyncPolicySpec{
+			ResourceNamePattern: "aks-gpupool-*",
+			Priority:            10,
+		}),
+		newFakePolicy("system", v1alpha1.NodeLabelSyncPolicySpec{
+			NodeSelector:        &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.azure.com/mode": "system"}},
+			ResourceNamePattern: "aks-system-*",
+			Priority:            5,
+		}),
+	}
+
+	var selectPolicyTest = []struct {
+		name         string
+		labels       map[string]string
+		resourceName string
+		expected     string
+	}{
+		{"no selector matches", map[string]string{}, "aks-userpool-1234", "default"},
+		{"node selector", map[string]string{"accelerator": "nvidia"}, "aks-userpool-1234", "gpu"},
+		{"resource name pattern", map[string]string{}, "aks-gpupool-1234", "gpu-pool"},
+		{"equal priority ordered by name", map[string]string{"accelerator": "nvidia"}, "aks-gpupool-1234", "gpu"},
+		{"selector and pattern both required", map[string]string{"kubernetes.azure.com/mode": "system"}, "aks-userpool-1234", "default"},
+		{"selector and pattern", map[string]string{"kubernetes.azure.com/mode": "system"}, "aks-system-1234", "system"},
+	}
+
+	for _, tt := range selectPolicyTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{}
+			node.Labels = tt.labels
+			policy, invalid := SelectPolicy(policies, node, tt.resourceName)
+			assert.Empty(t, invalid)
+			assert.Equal(t, tt.expected, policy.Name)
+		})
+	}
+}
+
+func TestSelectPolicyNoMatch(t *testing.T) {
+	policies := []v1alpha1.NodeLabelSyncPolicy{
+		newFakePolicy("gpu-pool", v1alpha1.NodeLabelSyncPolicySpec{ResourceNamePattern: "aks-gpupool-*"}),
+	}
+	policy, invalid := SelectPolicy(policies, &corev1.Node{}, "aks-userpool-1234")
+	assert.Empty(t, invalid)
+	assert.Nil(t, policy)
+}
+
+func TestSelectPolicyInvalidPattern(t *testing.T) {
+	policies := []v1alpha1.NodeLabelSyncPolicy{
+		newFakePolicy("bad", v1alpha1.NodeLabelSyncPolicySpec{ResourceNamePattern: "aks-[gpupool", Priority: 10}),
+		newFakePolicy("bad-selector", v1alpha1.NodeLabelSyncPolicySpec{
+			NodeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "pool", Operator: "Near"}}},
+			Priority:     5,
+		}),
+		newFakePolicy("good", v1alpha1.NodeLabelSyncPolicySpec{ResourceNamePattern: "aks-userpool-*"}),
+	}
+	policy, invalid := SelectPolicy(policies, &corev1.Node{}, "aks-userpool-1234")
+	if assert.NotNil(t, policy) {
+		assert.Equal(t, "good", policy.Name)
+	}
+	if assert.Len(t, invalid, 2) {
+		assert.Equal(t, "bad", invalid[0].Policy.Name)
+		assert.Error(t, invalid[0].Err)
+		assert.Equal(t, "bad-selector", invalid[1].Policy.Name)
+	}
+	_, err := NewConfigFromPolicy(&policies[0])
+	assert.Error(t, err)
+}
+
+func newFakePolicy(name string, spec v1alpha1.NodeLabelSyncPolicySpec) v1alpha1.NodeLabelSyncPolicy {
+	policy := v1alpha1.NodeLabelSyncPolicy{Spec: spec}
+	policy.Name = name
+	return policy
+}
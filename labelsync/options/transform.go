@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+)
+
+var validReplacement = regexp.MustCompile("^[-A-Za-z0-9_.]*$")
+
+// TagTransform -> naming.Transform, compiling rename patterns. Returns nil if spec is nil.
+func NewTransform(spec *v1alpha1.TagTransform) (*naming.Transform, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	transform := &naming.Transform{
+		NameMappings:  spec.NameMappings,
+		ValueMappings: spec.ValueMappings,
+		Lowercase:     spec.Lowercase,
+		HashTruncate:  spec.HashTruncate,
+	}
+	for i, rename := range spec.Renames {
+		pattern, err := regexp.Compile(rename.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rename %d: %v", i, err)
+		}
+		transform.Renames = append(transform.Renames, naming.RenameRule{Pattern: pattern, Replacement: rename.Replacement})
+	}
+	if spec.InvalidCharReplacement != nil {
+		if !validReplacement.MatchString(*spec.InvalidCharReplacement) {
+			return nil, fmt.Errorf("invalid character replacement %q, must only contain alphanumerics, '-', '_' or '.'", *spec.InvalidCharReplacement)
+		}
+		transform.ReplaceInvalidChars = true
+		transform.InvalidCharReplacement = *spec.InvalidCharReplacement
+	}
+	return transform, nil
+}
@@ -0,0 +1,148 @@
+package labelsync
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+// node annotation with a JSON list of the ARM tags written from the node's labels.
+// Only tags in this list are ever deleted by node-to-arm sync.
+const OwnedTagsAnnotation string = "nodelabel.azure.com/owned-tags"
+
+// OwnedTags returns the set of tags the node has written to its Azure resource
+func OwnedTags(node *corev1.Node) map[string]bool {
+	owned := map[string]bool{}
+	val, ok := node.Annotations[OwnedTagsAnnotation]
+	if !ok {
+		return owned
+	}
+	var tagNames []string
+	if err := json.Unmarshal([]byte(val), &tagNames); err != nil {
+		return owned // treat a corrupted record as owning nothing, so no tags are deleted
+	}
+	for _, tagName := range tagNames {
+		owned[tagName] = true
+	}
+	return owned
+}
+
+// OwnedTagsAnnotationValue returns the annotation value recording the given tags as owned
+func OwnedTagsAnnotationValue(owned map[string]bool) (string, error) {
+	tagNames := make([]string, 0, len(owned))
+	for tagName := range owned {
+		tagNames = append(tagNames, tagName)
+	}
+	sort.Strings(tagNames)
+	b, err := json.Marshal(tagNames)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// TagNamesForLabels returns the names of the tags that the node's labels are synced to, mapped the same
+// way LabelsToAzureResource maps them, given the tags currently on the resource. Labels synced from tags
+// with the tag prefix are left out, since those tags were written from the labels of other nodes.
+func TagNamesForLabels(node *corev1.Node, tags map[string]*string, configOptions *options.ConfigOptions) map[string]bool {
+	tagsByLabelName := labelSources(tags, configOptions)
+	tagNames := map[string]bool{}
+	for labelName, labelVal := range node.Labels {
+		if !configOptions.LabelFilter.Allowed(labelName) ||
+			!naming.ValidTagName(labelName, configOptions.LabelPrefix) || !naming.ValidTagVal(labelVal) {
+			continue
+		}
+		tagName, _ := labelToTag(labelName, labelVal, tags, tagsByLabelName, configOptions)
+		if len(tagName) > naming.MaxTagNameLen {
+			continue
+		}
+		if naming.HasLabelPrefix(labelName, configOptions.LabelPrefix) && naming.HasTagPrefix(tagName, configOptions.TagPrefix) {
+			continue
+		}
+		tagNames[tagName] = true
+	}
+	return tagNames
+}
+
+// whether an owned tag without the tag prefix was written from a label of one of the nodes before the tag
+// prefix was set, so it has been replaced by the tag with the prefix
+func legacyTag(tagName string, nodes []*corev1.Node, configOptions *options.ConfigOptions) bool {
+	if configOptions.TagPrefix == "" || naming.HasTagPrefix(tagName, configOptions.TagPrefix) {
+		return false
+	}
+	for _, n := range nodes {
+		if _, ok := n.Labels[tagName]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StaleTags returns the owned tags whose labels have been removed from the node, with nil values.
+// A tag is only stale if none of the given nodes still have its label. Owned tags written without the
+// tag prefix for labels that are now written with it are stale too, so they're migrated to the new names.
+func StaleTags(computeResource azrsrc.ComputeResource, node *corev1.Node, siblings []corev1.Node,
+	configOptions *options.ConfigOptions, log logr.Logger) map[string]*string {
+
+	nodes := []*corev1.Node{node}
+	for i := range siblings {
+		nodes = append(nodes, &siblings[i])
+	}
+
+	staleTags := map[string]*string{}
+	legacy := map[string]bool{}
+	current := TagNamesForLabels(node, computeResource.Tags(), configOptions)
+	for tagName := range OwnedTags(node) {
+		if _, ok := computeResource.Tags()[tagName]; !ok {
+			continue
+		}
+		if legacyTag(tagName, nodes, configOptions) {
+			log.V(1).Info("deleting tag replaced by tag with prefix", "tag name", tagName, "tag prefix", configOptions.TagPrefix)
+			legacy[tagName] = true
+		} else if current[tagName] {
+			continue
+		}
+		staleTags[tagName] = nil
+	}
+	if len(staleTags) == 0 {
+		return staleTags
+	}
+
+	for i := range siblings {
+		for tagName := range TagNamesForLabels(&siblings[i], computeResource.Tags(), configOptions) {
+			if _, ok := staleTags[tagName]; ok && !legacy[tagName] {
+				log.V(1).Info("keeping tag still labeled on node", "tag name", tagName, "node", siblings[i].Name)
+				delete(staleTags, tagName)
+			}
+		}
+	}
+	return staleTags
+}
+
+// UpdateOwnedTags returns the tags owned by the node after its changes have been applied to the
+// Azure resource. Only tags that the node's own labels map to are claimed, not tags written from the
+// labels of other nodes on the resource. Of those, the node claims the tags that were just written, and
+// tags with the tag prefix even if another node wrote them, so that the last node to remove a label on a
+// shared VMSS can delete its tag. Tags that no longer exist on the resource are dropped.
+func UpdateOwnedTags(computeResource azrsrc.ComputeResource, node *corev1.Node, changes map[string]*string,
+	configOptions *options.ConfigOptions) map[string]bool {
+
+	owned := OwnedTags(node)
+	for tagName := range TagNamesForLabels(node, computeResource.Tags(), configOptions) {
+		if changes[tagName] != nil || naming.HasTagPrefix(tagName, configOptions.TagPrefix) {
+			owned[tagName] = true
+		}
+	}
+	for tagName := range owned {
+		if _, ok := computeResource.Tags()[tagName]; !ok {
+			delete(owned, tagName)
+		}
+	}
+	return owned
+}
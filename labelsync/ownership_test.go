@@ -0,0 +1,217 @@
+package labelsync
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+func TestStaleTags(t *testing.T) {
+	fruitTag := naming.TagWithPrefix("favfruit", options.DefaultTagPrefix)
+	vegTag := naming.TagWithPrefix("favveg", options.DefaultTagPrefix)
+
+	var staleTagsTest = []struct {
+		name          string
+		tags          map[string]*string
+		labels        map[string]string
+		owned         []string
+		siblingLabels []map[string]string
+		expected      map[string]*string
+	}{
+		{
+			"removed label deletes owned tag",
+			map[string]*string{fruitTag: to.StringPtr("banana"), vegTag: to.StringPtr("kale")},
+			map[string]string{"favveg": "kale"},
+			[]string{fruitTag, vegTag},
+			nil,
+			map[string]*string{fruitTag: nil},
+		},
+		{
+			"tag not owned by node is kept",
+			map[string]*string{fruitTag: to.StringPtr("banana"), "env": to.StringPtr("test")},
+			map[string]string{},
+			[]string{fruitTag},
+			nil,
+			map[string]*string{fruitTag: nil},
+		},
+		{
+			"tag still labeled on another node is kept",
+			map[string]*string{fruitTag: to.StringPtr("banana"), vegTag: to.StringPtr("kale")},
+			map[string]string{},
+			[]string{fruitTag, vegTag},
+			[]map[string]string{{"favfruit": "banana"}, {}},
+			map[string]*string{vegTag: nil},
+		},
+		{
+			"tag already removed from resource",
+			map[string]*string{},
+			map[string]string{},
+			[]string{fruitTag},
+			nil,
+			map[string]*string{},
+		},
+		{
+			"label synced back from the tag doesn't keep it",
+			map[string]*string{fruitTag: to.StringPtr("banana")},
+			map[string]string{},
+			[]string{fruitTag},
+			[]map[string]string{{naming.LabelWithPrefix("favfruit", options.DefaultLabelPrefix): "banana"}},
+			map[string]*string{fruitTag: nil},
+		},
+		{
+			"tag written before the tag prefix was set",
+			map[string]*string{fruitTag: to.StringPtr("banana"), "favfruit": to.StringPtr("banana")},
+			map[string]string{"favfruit": "banana", naming.LabelWithPrefix("favfruit", options.DefaultLabelPrefix): "banana"},
+			[]string{fruitTag, "favfruit"},
+			[]map[string]string{{naming.LabelWithPrefix("favfruit", options.DefaultLabelPrefix): "banana"}},
+			map[string]*string{"favfruit": nil},
+		},
+		{
+			"no ownership record",
+			map[string]*string{fruitTag: to.StringPtr("banana")},
+			map[string]string{},
+			nil,
+			nil,
+			map[string]*string{},
+		},
+	}
+
+	configOptions := options.DefaultConfigOptions()
+	for _, tt := range staleTagsTest {
+		t.Run(tt.name, func(t *testing.T) {
+			computeResource := azrsrc.NewFakeComputeResource(tt.tags)
+			node := NewFakeNode("node", tt.labels)
+			setOwnedTags(t, node, tt.owned)
+			var siblings []corev1.Node
+			for _, labels := range tt.siblingLabels {
+				siblings = append(siblings, *NewFakeNode("sibling", labels))
+			}
+			staleTags := StaleTags(computeResource, node, siblings, &configOptions, ctrl.Log)
+			assert.Equal(t, tt.expected, staleTags)
+		})
+	}
+}
+
+func TestStaleTagsTransformedNames(t *testing.T) {
+	longTag := strings.Repeat("a", naming.MaxLabelNameLen+7)
+	var roundTripTest = []struct {
+		name      string
+		tagName   string
+		transform *naming.Transform
+	}{
+		{"transformed", "Team Name", &naming.Transform{Lowercase: true, ReplaceInvalidChars: true, InvalidCharReplacement: "_"}},
+		{"truncated", longTag, nil},
+	}
+
+	for _, tt := range roundTripTest {
+		t.Run(tt.name, func(t *testing.T) {
+			configOptions := options.DefaultConfigOptions()
+			configOptions.SyncDirection = options.TwoWay
+			configOptions.ConflictPolicy = options.NodePrecedence
+			configOptions.Transform = tt.transform
+			log := ctrl.Log.WithName("node-label-operator-test")
+			computeResource := azrsrc.NewFakeComputeResource(map[string]*string{tt.tagName: to.StringPtr("old")})
+			node := NewFakeNode("node1", map[string]string{})
+
+			// the tag is synced to a label under a different name, whose value is then changed on the node
+			patch, err := TagsToNodes(defaultNamespacedName("node1"), computeResource, node, &configOptions, log, record.NewFakeRecorder(10))
+			require.NoError(t, err)
+			spec := map[string]map[string]map[string]string{}
+			require.NoError(t, json.Unmarshal(patch, &spec))
+			require.Len(t, spec["metadata"]["labels"], 1)
+			for labelName := range spec["metadata"]["labels"] {
+				assert.NotEqual(t, naming.LabelWithPrefix(tt.tagName, configOptions.LabelPrefix), labelName)
+				node.Labels[labelName] = "new"
+			}
+
+			// the new value is written to the original tag, which the node then owns
+			changes, err := LabelsToAzureResource(defaultNamespacedName("node1"), computeResource, node, &configOptions, log, record.NewFakeRecorder(10))
+			require.NoError(t, err)
+			assert.Equal(t, map[string]*string{tt.tagName: to.StringPtr("new")}, changes)
+			computeResource.Tags()[tt.tagName] = changes[tt.tagName]
+			owned := UpdateOwnedTags(computeResource, node, changes, &configOptions)
+			assert.True(t, owned[tt.tagName])
+			setOwnedTags(t, node, []string{tt.tagName})
+
+			// and isn't stale while the node has the label
+			assert.Equal(t, map[string]*string{}, StaleTags(computeResource, node, nil, &configOptions, log))
+		})
+	}
+}
+
+func TestUpdateOwnedTags(t *testing.T) {
+	configOptions := options.DefaultConfigOptions()
+	fruitTag := naming.TagWithPrefix("favfruit", options.DefaultTagPrefix)
+	vegTag := naming.TagWithPrefix("favveg", options.DefaultTagPrefix)
+	oldTag := naming.TagWithPrefix("old", options.DefaultTagPrefix)
+
+	// favveg was written by another node, old was deleted by a human, env was never synced from a label
+	computeResource := azrsrc.NewFakeComputeResource(map[string]*string{
+		fruitTag: to.StringPtr("banana"),
+		vegTag:   to.StringPtr("kale"),
+		"env":    to.StringPtr("test"),
+	})
+	node := NewFakeNode("node", map[string]string{"favfruit": "banana", "favveg": "kale"})
+	setOwnedTags(t, node, []string{oldTag})
+
+	owned := UpdateOwnedTags(computeResource, node, map[string]*string{fruitTag: to.StringPtr("banana")}, &configOptions)
+	assert.Equal(t, map[string]bool{fruitTag: true, vegTag: true}, owned)
+
+	val, err := OwnedTagsAnnotationValue(owned)
+	require.NoError(t, err)
+	node.Annotations[OwnedTagsAnnotation] = val
+	assert.Equal(t, owned, OwnedTags(node))
+}
+
+func TestUpdateOwnedTagsAggregated(t *testing.T) {
+	configOptions := options.DefaultConfigOptions()
+	configOptions.TagPrefix = ""
+	configOptions.LabelAggregation = options.Any
+
+	// the tags written for the combined labels of both nodes
+	changes := map[string]*string{"favfruit": to.StringPtr("banana"), "favveg": to.StringPtr("kale")}
+	computeResource := azrsrc.NewFakeComputeResource(changes)
+	node1 := NewFakeNode("node1", map[string]string{"favfruit": "banana"})
+	node2 := NewFakeNode("node2", map[string]string{"favveg": "kale"})
+
+	// each node owns the tag of its own label
+	assert.Equal(t, map[string]bool{"favfruit": true}, UpdateOwnedTags(computeResource, node1, changes, &configOptions))
+	assert.Equal(t, map[string]bool{"favveg": true}, UpdateOwnedTags(computeResource, node2, changes, &configOptions))
+
+	// so removing a label deletes its tag once no node has it, whichever node wrote it
+	node1.Labels = map[string]string{}
+	setOwnedTags(t, node1, []string{"favfruit"})
+	setOwnedTags(t, node2, []string{"favveg"})
+	assert.Equal(t, map[string]*string{"favfruit": nil}, StaleTags(computeResource, node1, []corev1.Node{*node2}, &configOptions, ctrl.Log))
+	assert.Equal(t, map[string]*string{}, StaleTags(computeResource, node2, []corev1.Node{*node1}, &configOptions, ctrl.Log))
+}
+
+func TestOwnedTagsCorruptedRecord(t *testing.T) {
+	node := NewFakeNode("node", map[string]string{})
+	node.Annotations = map[string]string{OwnedTagsAnnotation: "not json"}
+	assert.Empty(t, OwnedTags(node))
+}
+
+func setOwnedTags(t *testing.T, node *corev1.Node, tagNames []string) {
+	if tagNames == nil {
+		return
+	}
+	owned := map[string]bool{}
+	for _, tagName := range tagNames {
+		owned[tagName] = true
+	}
+	val, err := OwnedTagsAnnotationValue(owned)
+	require.NoError(t, err)
+	node.Annotations = map[string]string{OwnedTagsAnnotation: val}
+}
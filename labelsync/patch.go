@@ -22,6 +22,19 @@ func LabelPatchWithDelete(labels map[string]*string) ([]byte, error) {
 	})
 }
 
+func MetadataPatch(labels map[string]*string, annotations map[string]*string) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+	})
+}
+
 func labelDeletionAllowed(configOptions *options.ConfigOptions) bool {
 	return configOptions.LabelPrefix != "" && (configOptions.ConflictPolicy == options.ARMPrecedence || configOptions.ConflictPolicy == options.Ignore)
 }
@@ -0,0 +1,74 @@
+package labelsync
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/naming"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+// return patch applying the Azure platform metadata of the node's resource as labels with the platform label
+// prefix, and deleting platform labels that no longer apply, or nil if there are no changes
+func PlatformLabels(metadata map[string]string, node *corev1.Node, configOptions *options.ConfigOptions,
+	log logr.Logger) ([]byte, error) {
+
+	newLabels := map[string]*string{}
+	for key, val := range metadata {
+		if !naming.ValidLabelVal(val) {
+			log.V(0).Info("invalid label value", "platform metadata", key, "value", val)
+			continue
+		}
+		labelName := naming.LabelWithPrefix(key, configOptions.PlatformLabelPrefix)
+		if labelVal, ok := node.Labels[labelName]; !ok || labelVal != val {
+			log.V(1).Info("applying platform metadata to node", "label name", labelName, "label value", val)
+			newLabels[labelName] = to.StringPtr(val)
+		}
+	}
+
+	for labelFullName := range node.Labels {
+		key, ok := platformKey(labelFullName, configOptions.PlatformLabelPrefix)
+		if !ok {
+			continue
+		}
+		if _, ok := metadata[key]; !ok {
+			log.V(1).Info("deleting platform label from node", "label name", labelFullName)
+			newLabels[labelFullName] = nil
+		}
+	}
+
+	if len(newLabels) == 0 {
+		return nil, nil
+	}
+
+	return LabelPatchWithDelete(newLabels)
+}
+
+// remove the platform labels with the given prefix from node, returning them with nil values for a merge patch.
+// Other labels with the prefix weren't written by the operator, so they're kept.
+func DeletePlatformLabels(node *corev1.Node, platformLabelPrefix string) map[string]*string {
+	deleted := map[string]*string{}
+	for labelFullName := range node.Labels {
+		if _, ok := platformKey(labelFullName, platformLabelPrefix); ok {
+			delete(node.Labels, labelFullName)
+			deleted[labelFullName] = nil
+		}
+	}
+	return deleted
+}
+
+// return the platform metadata key of the label, if it's a platform label with the prefix
+func platformKey(labelFullName, platformLabelPrefix string) (string, bool) {
+	if !naming.HasLabelPrefix(labelFullName, platformLabelPrefix) {
+		return "", false
+	}
+	key := naming.LabelWithoutPrefix(labelFullName, platformLabelPrefix)
+	for _, platformKey := range azrsrc.PlatformKeys {
+		if key == platformKey {
+			return key, true
+		}
+	}
+	return "", false
+}
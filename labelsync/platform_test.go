@@ -0,0 +1,69 @@
+package labelsync
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+func TestPlatformLabels(t *testing.T) {
+	var platformLabelsTest = []struct {
+		name                string
+		metadata            map[string]string
+		labels              map[string]string
+		expectedPatchLabels map[string]interface{}
+	}{
+		{
+			"new labels",
+			map[string]string{azrsrc.PlatformSize: "Standard_DS2_v2", azrsrc.PlatformPriority: "Low"},
+			map[string]string{"azure.tags/env": "test"},
+			map[string]interface{}{"azure.platform/size": "Standard_DS2_v2", "azure.platform/priority": "Low"},
+		},
+		{
+			"unchanged",
+			map[string]string{azrsrc.PlatformSize: "Standard_DS2_v2"},
+			map[string]string{"azure.platform/size": "Standard_DS2_v2"},
+			nil,
+		},
+		{
+			"changed and removed metadata",
+			map[string]string{azrsrc.PlatformSize: "Standard_DS3_v2"},
+			map[string]string{"azure.platform/size": "Standard_DS2_v2", "azure.platform/proximity-placement-group": "ppg1"},
+			map[string]interface{}{"azure.platform/size": "Standard_DS3_v2", "azure.platform/proximity-placement-group": nil},
+		},
+		{
+			"other labels with the prefix",
+			map[string]string{},
+			map[string]string{"azure.platform/team": "a"},
+			nil,
+		},
+		{
+			"invalid label value",
+			map[string]string{azrsrc.PlatformImage: "image name with spaces"},
+			map[string]string{},
+			nil,
+		},
+	}
+
+	configOptions := options.DefaultConfigOptions()
+	configOptions.PlatformLabelPrefix = "azure.platform"
+	for _, tt := range platformLabelsTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node", tt.labels)
+			patch, err := PlatformLabels(tt.metadata, node, &configOptions, ctrl.Log)
+			assert.NoError(t, err)
+			if tt.expectedPatchLabels == nil {
+				assert.Nil(t, patch)
+				return
+			}
+			spec := map[string]map[string]map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, tt.expectedPatchLabels, spec["metadata"]["labels"])
+		})
+	}
+}
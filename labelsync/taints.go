@@ -0,0 +1,134 @@
+package labelsync
+
+import (
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+// node annotation with a JSON list of the taints added from ARM tags. Only taints in this list
+// are ever removed from the node.
+const OwnedTaintsAnnotation string = "nodelabel.azure.com/owned-taints"
+
+// return patch with the node's taints updated from the ARM tags using the policy's taint mappings,
+// otherwise return nil for no changes or an error
+func TagsToTaints(computeResource azrsrc.ComputeResource, node *corev1.Node, configOptions *options.ConfigOptions,
+	log logr.Logger) ([]byte, error) {
+
+	desired := desiredTaints(computeResource.Tags(), configOptions, log)
+	owned := OwnedTaints(node)
+
+	var taints, newOwned []corev1.Taint
+	for _, taint := range node.Spec.Taints {
+		if !containsTaint(owned, taint) {
+			taints = append(taints, taint) // not added by the operator, so leave it alone
+		} else if containsTaint(desired, taint) {
+			taints = append(taints, taint)
+			newOwned = append(newOwned, taint)
+		} else {
+			log.V(1).Info("removing taint for removed tag", "taint", taint.ToString())
+		}
+	}
+	for _, taint := range desired {
+		if containsTaint(newOwned, taint) {
+			continue
+		}
+		if existing := findTaint(taints, taint.Key, taint.Effect); existing != nil {
+			log.V(0).Info("taint with the same key and effect already exists, leaving unchanged",
+				"taint", taint.ToString(), "existing taint", existing.ToString())
+			continue
+		}
+		log.V(1).Info("applying tag to node as taint", "taint", taint.ToString())
+		taints = append(taints, taint)
+		newOwned = append(newOwned, taint)
+	}
+
+	if len(taints) == len(node.Spec.Taints) && len(newOwned) == len(owned) && sameTaints(taints, node.Spec.Taints) {
+		return nil, nil
+	}
+
+	var ownedVal *string
+	if len(newOwned) > 0 {
+		b, err := json.Marshal(newOwned)
+		if err != nil {
+			return nil, err
+		}
+		val := string(b)
+		ownedVal = &val
+	}
+	metadata := map[string]interface{}{
+		"annotations": map[string]*string{OwnedTaintsAnnotation: ownedVal},
+	}
+	if node.ResourceVersion != "" {
+		// the whole taint list is replaced, so fail on conflict rather than drop a taint added concurrently
+		metadata["resourceVersion"] = node.ResourceVersion
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+		"spec":     map[string]interface{}{"taints": taints},
+	})
+}
+
+// OwnedTaints returns the taints the operator added to the node
+func OwnedTaints(node *corev1.Node) []corev1.Taint {
+	val, ok := node.Annotations[OwnedTaintsAnnotation]
+	if !ok {
+		return nil
+	}
+	var taints []corev1.Taint
+	if err := json.Unmarshal([]byte(val), &taints); err != nil {
+		return nil // treat a corrupted record as owning nothing, so no taints are removed
+	}
+	return taints
+}
+
+// taints the tags map to. When several mappings give the same key and effect, the first one wins.
+func desiredTaints(tags map[string]*string, configOptions *options.ConfigOptions, log logr.Logger) []corev1.Taint {
+	var taints []corev1.Taint
+	for _, mapping := range configOptions.Taints {
+		tagVal, ok := tags[mapping.TagName]
+		if !ok || tagVal == nil || (mapping.TagValue != "" && mapping.TagValue != *tagVal) {
+			continue
+		}
+		taint := corev1.Taint{Key: mapping.Key, Value: mapping.Value, Effect: mapping.Effect}
+		if taint.Value == "" {
+			if errs := validation.IsValidLabelValue(*tagVal); len(errs) > 0 {
+				log.V(0).Info("invalid taint value", "tag name", mapping.TagName, "tag value", *tagVal)
+				continue
+			}
+			taint.Value = *tagVal
+		}
+		if findTaint(taints, taint.Key, taint.Effect) == nil {
+			taints = append(taints, taint)
+		}
+	}
+	return taints
+}
+
+func findTaint(taints []corev1.Taint, key string, effect corev1.TaintEffect) *corev1.Taint {
+	for i := range taints {
+		if taints[i].Key == key && taints[i].Effect == effect {
+			return &taints[i]
+		}
+	}
+	return nil
+}
+
+func containsTaint(taints []corev1.Taint, taint corev1.Taint) bool {
+	existing := findTaint(taints, taint.Key, taint.Effect)
+	return existing != nil && existing.Value == taint.Value
+}
+
+func sameTaints(a, b []corev1.Taint) bool {
+	for i := range a {
+		if !containsTaint(b, a[i]) {
+			return false
+		}
+	}
+	return true
+}
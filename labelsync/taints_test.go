@@ -0,0 +1,130 @@
+package labelsync
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+func TestTagsToTaints(t *testing.T) {
+	gpuTaint := corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+	tierTaint := corev1.Taint{Key: "tier", Value: "batch", Effect: corev1.TaintEffectPreferNoSchedule}
+	userTaint := corev1.Taint{Key: "maintenance", Value: "true", Effect: corev1.TaintEffectNoExecute}
+	mappings := []v1alpha1.TagTaint{
+		{TagName: "workload", TagValue: "gpu", Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+		{TagName: "tier", Key: "tier", Effect: corev1.TaintEffectPreferNoSchedule}, // value from tag
+	}
+
+	var taintTest = []struct {
+		name           string
+		tags           map[string]*string
+		taints         []corev1.Taint
+		owned          []corev1.Taint
+		expectPatch    bool
+		expectedTaints []corev1.Taint
+		expectedOwned  []corev1.Taint
+	}{
+		{
+			"add taints",
+			map[string]*string{"workload": to.StringPtr("gpu"), "tier": to.StringPtr("batch")},
+			[]corev1.Taint{userTaint},
+			nil,
+			true,
+			[]corev1.Taint{userTaint, gpuTaint, tierTaint},
+			[]corev1.Taint{gpuTaint, tierTaint},
+		},
+		{
+			"unchanged",
+			map[string]*string{"workload": to.StringPtr("gpu")},
+			[]corev1.Taint{gpuTaint, userTaint},
+			[]corev1.Taint{gpuTaint},
+			false,
+			nil,
+			nil,
+		},
+		{
+			"tag removed",
+			map[string]*string{"tier": to.StringPtr("batch")},
+			[]corev1.Taint{gpuTaint, userTaint, tierTaint},
+			[]corev1.Taint{gpuTaint, tierTaint},
+			true,
+			[]corev1.Taint{userTaint, tierTaint},
+			[]corev1.Taint{tierTaint},
+		},
+		{
+			"tag value not matched",
+			map[string]*string{"workload": to.StringPtr("cpu")},
+			[]corev1.Taint{gpuTaint},
+			[]corev1.Taint{gpuTaint},
+			true,
+			nil,
+			nil,
+		},
+		{
+			"taint not owned by operator is left alone",
+			map[string]*string{},
+			[]corev1.Taint{gpuTaint},
+			nil,
+			false,
+			nil,
+			nil,
+		},
+		{
+			"existing taint with same key and effect",
+			map[string]*string{"tier": to.StringPtr("batch")},
+			[]corev1.Taint{{Key: "tier", Value: "web", Effect: corev1.TaintEffectPreferNoSchedule}},
+			nil,
+			false,
+			nil,
+			nil,
+		},
+	}
+
+	configOptions := options.DefaultConfigOptions()
+	configOptions.Taints = mappings
+	for _, tt := range taintTest {
+		t.Run(tt.name, func(t *testing.T) {
+			node := NewFakeNode("node", map[string]string{})
+			node.ResourceVersion = "42"
+			node.Spec.Taints = tt.taints
+			if tt.owned != nil {
+				b, err := json.Marshal(tt.owned)
+				assert.NoError(t, err)
+				node.Annotations = map[string]string{OwnedTaintsAnnotation: string(b)}
+			}
+
+			patch, err := TagsToTaints(azrsrc.NewFakeComputeResource(tt.tags), node, &configOptions, ctrl.Log)
+			assert.NoError(t, err)
+			if !tt.expectPatch {
+				assert.Nil(t, patch)
+				return
+			}
+
+			var spec struct {
+				Metadata struct {
+					ResourceVersion string             `json:"resourceVersion"`
+					Annotations     map[string]*string `json:"annotations"`
+				} `json:"metadata"`
+				Spec struct {
+					Taints []corev1.Taint `json:"taints"`
+				} `json:"spec"`
+			}
+			assert.NoError(t, json.Unmarshal(patch, &spec))
+			assert.Equal(t, "42", spec.Metadata.ResourceVersion)
+			assert.Equal(t, tt.expectedTaints, spec.Spec.Taints)
+			node.Annotations = map[string]string{}
+			if owned := spec.Metadata.Annotations[OwnedTaintsAnnotation]; owned != nil {
+				node.Annotations[OwnedTaintsAnnotation] = *owned
+			}
+			assert.Equal(t, tt.expectedOwned, OwnedTaints(node))
+		})
+	}
+}
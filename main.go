@@ -26,10 +26,16 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	// +kubebuilder:scaffold:imports
 
+	nodelabelv1alpha1 "github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/azure"
+	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
 	"github.com/Azure/node-label-operator/controller"
+	"github.com/Azure/node-label-operator/webhook"
 )
 
 var (
@@ -41,6 +47,7 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = appsv1.AddToScheme(scheme)
+	_ = nodelabelv1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -48,10 +55,56 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var syncPeriod string
+	var enableWebhooks bool
+	var armCacheTTL time.Duration
+	var armCacheRefresh time.Duration
+	var armReadQPS float64
+	var armWriteQPS float64
+	var armBurst int
+	var authMode string
+	var credentials azure.CredentialConfig
+	var credentialsSecret string
+	var subscriptionCredentials string
+	var cloud string
+	var cloudConfig string
+	var maxConcurrentReconciles int
+	var reconcileTimeout time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&syncPeriod, "sync-period", "10h" /*1h*/, "Min frequency that tags and nodes are reconciled. Give time as integer with suffixes ns, us, ms, s, m, or h. Ex: \"100ns\" or \"2h30m\". Default is \"10h\".")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the admission webhooks that default and validate NodeLabelSyncPolicy resources. Requires serving certificates in /tmp/k8s-webhook-server/serving-certs.")
+	flag.DurationVar(&armCacheTTL, "arm-cache-ttl", time.Minute,
+		"How long VMs and VMSSs read from ARM are cached, so nodes on the same resource share reads. 0 disables the cache.")
+	flag.DurationVar(&armCacheRefresh, "arm-cache-refresh", 0,
+		"How often cached VMs and VMSSs are read again in the background. 0 disables background refresh.")
+	flag.Float64Var(&armReadQPS, "arm-read-qps", 3,
+		"Max ARM reads per second in each subscription. ARM allows 12000 reads an hour per subscription.")
+	flag.Float64Var(&armWriteQPS, "arm-write-qps", 0.3,
+		"Max ARM writes per second in each subscription. ARM allows 1200 writes an hour per subscription.")
+	flag.IntVar(&armBurst, "arm-burst", 10, "Max ARM requests sent at once in each subscription, above the read and write rates.")
+	flag.StringVar(&authMode, "auth-mode", string(azure.EnvironmentAuth),
+		"How to authenticate to Azure: environment, client-secret, client-certificate, managed-identity, workload-identity or azure-json.")
+	flag.StringVar(&credentials.TenantID, "tenant-id", "", "Azure AD tenant of the service principal or workload identity.")
+	flag.StringVar(&credentials.ClientID, "client-id", "",
+		"Client ID of the service principal, workload identity, or user-assigned managed identity.")
+	flag.StringVar(&credentialsSecret, "credentials-secret", "",
+		"Secret with the client secret or certificate, as namespace/name. It's read again when tokens are refreshed, so it can be rotated.")
+	flag.StringVar(&credentials.TokenFile, "federated-token-file", "",
+		"Projected service account token for workload-identity auth. Defaults to AZURE_FEDERATED_TOKEN_FILE.")
+	flag.StringVar(&credentials.AzureJSONPath, "azure-json-path", azure.DefaultAzureJSONPath,
+		"Cloud provider config file used for azure-json auth.")
+	flag.StringVar(&subscriptionCredentials, "subscription-credentials", "",
+		"JSON file mapping subscription IDs to the credentials used for them. Other subscriptions use the auth mode flags.")
+	flag.StringVar(&cloud, "cloud", "",
+		"Name of the Azure cloud, ex: AzureUSGovernmentCloud or AzureChinaCloud. Default is AZURE_ENVIRONMENT, or the public cloud if it isn't set.")
+	flag.StringVar(&cloudConfig, "cloud-config", "",
+		"JSON file with the endpoints of a custom cloud, such as Azure Stack Hub. Can't be used with --cloud.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"Number of VMs and VMSSs reconciled at once, each with all the nodes running on it.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 10*time.Minute,
+		"Deadline of each reconcile, including the time its ARM requests wait for the rate limit. \"0\" for no deadline.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.Logger(true))
@@ -62,6 +115,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := azure.ValidateRateLimits(armReadQPS, armWriteQPS, armBurst); err != nil {
+		setupLog.Error(err, "invalid ARM rate limits")
+		os.Exit(1)
+	}
+
+	environment, err := azure.LoadEnvironment(cloud, cloudConfig)
+	if err != nil {
+		setupLog.Error(err, "invalid Azure cloud")
+		os.Exit(1)
+	}
+	credentials.Mode = azure.AuthMode(authMode)
+	credentials.Environment = environment
+	if credentialsSecret != "" {
+		if credentials.Secret, err = azure.ParseSecretName(credentialsSecret); err != nil {
+			setupLog.Error(err, "invalid credentials-secret")
+			os.Exit(1)
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -73,17 +145,54 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.ReconcileNodeLabel{
-		Client:        mgr.GetClient(),
-		Log:           ctrl.Log.WithName("controllers"),
-		Scheme:        mgr.GetScheme(),
-		Recorder:      mgr.GetEventRecorderFor("node-label-operator"),
-		MinSyncPeriod: controller.FiveMinutes,
-	}).SetupWithManager(mgr); err != nil {
+	credentials.Secrets = mgr.GetAPIReader()
+	if err := credentials.Validate(); err != nil {
+		setupLog.Error(err, "invalid Azure credentials")
+		os.Exit(1)
+	}
+	var allCredentials azure.Credentials = credentials
+	if subscriptionCredentials != "" {
+		if allCredentials, err = azure.LoadSubscriptionCredentials(subscriptionCredentials, credentials); err != nil {
+			setupLog.Error(err, "invalid subscription credentials")
+			os.Exit(1)
+		}
+	}
+
+	var cache *azrsrc.Cache
+	if armCacheTTL > 0 {
+		cache = azrsrc.NewCache(armCacheTTL)
+		if armCacheRefresh > 0 {
+			if err := mgr.Add(manager.RunnableFunc(cache.RefreshEvery(armCacheRefresh))); err != nil {
+				setupLog.Error(err, "unable to add cache refresh")
+				os.Exit(1)
+			}
+		}
+	}
+
+	reconciler := &controller.ReconcileComputeResource{ReconcileNodeLabel: &controller.ReconcileNodeLabel{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("node-label-operator"),
+		MinSyncPeriod:           controller.FiveMinutes,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ReconcileTimeout:        reconcileTimeout,
+		Clients:                 azure.NewClients(environment, allCredentials, azure.NewRateLimiter(armReadQPS, armWriteQPS, armBurst)),
+		Cache:                   cache,
+		Health:                  azure.NewHealthTracker(controller.FiveMinutes),
+	}}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller")
 		os.Exit(1)
 	}
 	setupLog.Info("successfully registered controller")
+
+	if enableWebhooks {
+		hookServer := mgr.GetWebhookServer()
+		hookServer.Register(webhook.DefaultPolicyPath, &ctrlwebhook.Admission{Handler: &webhook.PolicyDefaulter{}})
+		hookServer.Register(webhook.ValidatePolicyPath, &ctrlwebhook.Admission{Handler: &webhook.PolicyValidator{}})
+		setupLog.Info("successfully registered webhooks")
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -20,6 +20,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/Azure/node-label-operator/api/v1alpha1"
 	"github.com/Azure/node-label-operator/azure"
 	azrsrc "github.com/Azure/node-label-operator/azure/computeresource"
 	"github.com/Azure/node-label-operator/labelsync"
@@ -104,7 +105,7 @@ func (s *TestSuite) TestNodeLabelToARMTag() {
 
 	// clean up compute resource by deleting tags, because currently operator doesn't do that for label->tag sync
 	for key := range labels {
-		delete(computeResource.Tags(), key)
+		delete(computeResource.Tags(), s.TagNameForLabel(key))
 	}
 	err := computeResource.Update(context.Background())
 	require.NoError(err)
@@ -165,16 +166,16 @@ func (s *TestSuite) TestTwoWaySync() {
 
 	// still need to remove labels from azure resource
 	for key := range labels {
-		delete(computeResource.Tags(), key)
+		delete(computeResource.Tags(), s.TagNameForLabel(key))
 	}
 	err := computeResource.Update(context.Background())
 	require.NoError(err)
 	assert.Equal(numStartingTags, len(computeResource.Tags()))
 
 	// check that tags and labels got deleted off each other
-	for key := range computeResource.Tags() {
+	for key := range labels {
 		// assert not in tags
-		_, ok := labels[key]
+		_, ok := computeResource.Tags()[s.TagNameForLabel(key)]
 		assert.False(ok)
 	}
 	for _, node := range computeResourceNodes {
@@ -373,7 +374,7 @@ func (s *TestSuite) TestConflictPolicyNodePrecedence() {
 
 	// clean up compute resource by deleting tags, because currently operator doesn't do that for label->tag sync
 	for key := range labels {
-		delete(computeResource.Tags(), key)
+		delete(computeResource.Tags(), s.TagNameForLabel(key))
 	}
 	err := computeResource.Update(context.Background())
 	require.NoError(err)
@@ -682,7 +683,7 @@ func (s *TestSuite) NewVMSS() azrsrc.VirtualMachineScaleSet {
 	assert := assert.New(s.T())
 	require := require.New(s.T())
 
-	vmssClient, err := azure.NewScaleSetClient(s.SubscriptionID) // I should check resource type here
+	vmssClient, err := s.azureClients.ScaleSetClient(s.SubscriptionID) // I should check resource type here
 	require.NoError(err)
 	vmssList, err := vmssClient.List(context.Background(), s.ResourceGroup)
 	if err != nil {
@@ -691,7 +692,6 @@ func (s *TestSuite) NewVMSS() azrsrc.VirtualMachineScaleSet {
 	require.NoError(err)
 	assert.NotEqual(0, len(vmssList.Values()))
 	vmss := vmssList.Values()[0]
-	vmss = azrsrc.VMSSUserAssignedIdentity(vmss)
 	s.T().Logf("Successfully found %d vmss: using %s", len(vmssList.Values()), *vmss.Name)
 	return *azrsrc.NewVMSSInitialized(context.Background(), s.ResourceGroup, &vmssClient, &vmss)
 }
@@ -701,7 +701,7 @@ func (s *TestSuite) NewVM() azrsrc.VirtualMachine {
 	require := require.New(s.T())
 
 	assert.True(s.ResourceType == azrsrc.VM)
-	vmClient, err := azure.NewVMClient(s.SubscriptionID)
+	vmClient, err := s.azureClients.VMClient(s.SubscriptionID)
 	require.NoError(err)
 	vmList, err := vmClient.List(context.Background(), s.ResourceGroup)
 	if err != nil {
@@ -710,26 +710,26 @@ func (s *TestSuite) NewVM() azrsrc.VirtualMachine {
 	require.NoError(err)
 	assert.NotEqual(0, len(vmList.Values()))
 	vm := vmList.Values()[0]
-	vm = azrsrc.VMUserAssignedIdentity(vm)
 	s.T().Logf("Successfully found %d vms: using %s", len(vmList.Values()), *vm.Name)
 	return *azrsrc.NewVMInitialized(context.Background(), s.ResourceGroup, &vmClient, &vm)
 }
 
 func (s *TestSuite) GetConfigOptions() *options.ConfigOptions {
-	var configMap corev1.ConfigMap
-	optionsNamespacedName := options.ConfigMapNamespacedName()
-	err := s.client.Get(context.Background(), optionsNamespacedName, &configMap)
+	var policy v1alpha1.NodeLabelSyncPolicy
+	err := s.client.Get(context.Background(), options.PolicyNamespacedName(), &policy)
 	require.NoError(s.T(), err)
-	configOptions, err := options.NewConfig(configMap)
+	configOptions, err := options.NewConfigFromPolicy(&policy)
 	require.NoError(s.T(), err)
 
 	return configOptions
 }
 
 func (s *TestSuite) UpdateConfigOptions(configOptions *options.ConfigOptions) {
-	configMap, err := options.GetConfigMapFromConfigOptions(configOptions)
+	var policy v1alpha1.NodeLabelSyncPolicy
+	err := s.client.Get(context.Background(), options.PolicyNamespacedName(), &policy)
 	require.NoError(s.T(), err)
-	err = s.client.Update(context.Background(), &configMap)
+	policy.Spec = options.GetPolicySpecFromConfigOptions(configOptions)
+	err = s.client.Update(context.Background(), &policy)
 	require.NoError(s.T(), err)
 
 	updatedConfigOptions := s.GetConfigOptions()
@@ -741,6 +741,12 @@ func (s *TestSuite) UpdateConfigOptions(configOptions *options.ConfigOptions) {
 		configOptions.SyncDirection, configOptions.ConflictPolicy, configOptions.MinSyncPeriod)
 }
 
+// tag name that node-to-arm sync writes a node label to
+func (s *TestSuite) TagNameForLabel(labelName string) string {
+	configOptions := s.GetConfigOptions()
+	return naming.ConvertLabelNameToValidTagName(labelName, configOptions.LabelPrefix, configOptions.TagPrefix)
+}
+
 func (s *TestSuite) GetNodes() *corev1.NodeList {
 	nodeList := &corev1.NodeList{}
 	err := s.client.List(context.Background(), nodeList)
@@ -864,7 +870,7 @@ func (s *TestSuite) CheckAzComputeResourceTagsForLabels(computeResource azrsrc.C
 	}
 	numErrs := 0
 	for key, val := range labels {
-		v, ok := computeResource.Tags()[key]
+		v, ok := computeResource.Tags()[s.TagNameForLabel(key)]
 		if !ok {
 			s.T().Logf("expected Azure compute resource %s to have label %s", computeResource.Name(), key)
 			numErrs += 1
@@ -6,6 +6,7 @@ package tests
 import (
 	"context"
 	"errors"
+	"os"
 	"testing"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/Azure/node-label-operator/api/v1alpha1"
 	"github.com/Azure/node-label-operator/azure"
 	"github.com/Azure/node-label-operator/labelsync/options"
 )
@@ -27,6 +29,7 @@ var Scheme = runtime.NewScheme()
 func AddToScheme(scheme *runtime.Scheme) {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = corev1.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
 }
 
 type Cluster struct {
@@ -40,7 +43,8 @@ type TestSuite struct {
 	suite.Suite
 	suite.TearDownAllSuite
 	*Cluster
-	client client.Client
+	client       client.Client
+	azureClients azure.ClientFactory
 }
 
 func initialize(c *Cluster) error {
@@ -57,18 +61,16 @@ func loadConfigFromBytes(t *testing.T, kubeconfig_out string) *rest.Config {
 }
 
 func (s *TestSuite) setConfigOptions(minSyncPeriod string) {
-	var configMap corev1.ConfigMap
-	optionsNamespacedName := options.ConfigMapNamespacedName()
-	err := s.client.Get(context.Background(), optionsNamespacedName, &configMap)
+	var policy v1alpha1.NodeLabelSyncPolicy
+	err := s.client.Get(context.Background(), options.PolicyNamespacedName(), &policy)
 	require.NoError(s.T(), err)
-	configOptions, err := options.NewConfig(configMap)
+	configOptions, err := options.NewConfigFromPolicy(&policy)
 	require.NoError(s.T(), err)
 	configOptions.SyncDirection = options.ARMToNode
 	configOptions.ConflictPolicy = options.ARMPrecedence
 	configOptions.MinSyncPeriod = minSyncPeriod
-	configMap, err = options.GetConfigMapFromConfigOptions(configOptions)
-	require.NoError(s.T(), err)
-	err = s.client.Update(context.Background(), &configMap)
+	policy.Spec = options.GetPolicySpecFromConfigOptions(configOptions)
+	err = s.client.Update(context.Background(), &policy)
 	require.NoError(s.T(), err)
 }
 
@@ -80,6 +82,9 @@ func (s *TestSuite) SetupSuite() {
 	cl, err := client.New(loadConfigFromBytes(s.T(), s.KubeConfig), client.Options{Scheme: Scheme})
 	require.NoError(s.T(), err)
 	s.client = cl
+	environment, err := azure.LoadEnvironment(os.Getenv("AZURE_ENVIRONMENT"), "")
+	require.NoError(s.T(), err)
+	s.azureClients = azure.NewClients(environment, azure.CredentialConfig{Environment: environment}, nil)
 
 	// better to get metadata endpoint? would that be an issue w/ aad-pod-identity running?
 	nodeList := &corev1.NodeList{}
@@ -94,7 +99,7 @@ func (s *TestSuite) SetupSuite() {
 	s.ResourceGroup = resource.ResourceGroup
 	s.ResourceType = resource.ResourceType // ends up depending on which node is chosen first for aks-engine
 
-	s.T().Logf("Resetting configmap")
+	s.T().Logf("Resetting sync policy")
 	s.setConfigOptions("10s")
 	time.Sleep(90 * time.Second)
 }
@@ -103,7 +108,7 @@ func (s *TestSuite) SetupSuite() {
 func (s *TestSuite) TearDownSuite() {
 	s.T().Logf("\nTearDownSuite")
 
-	s.T().Logf("Resetting configmap")
+	s.T().Logf("Resetting sync policy")
 	s.setConfigOptions("1m")
 
 	// make sure necessary tags/labels deleted? I would maybe save current tags and current labels?
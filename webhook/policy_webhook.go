@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+	"github.com/Azure/node-label-operator/labelsync/options"
+)
+
+const (
+	ValidatePolicyPath string = "/validate-nodelabel-azure-com-v1alpha1-nodelabelsyncpolicy"
+	DefaultPolicyPath  string = "/mutate-nodelabel-azure-com-v1alpha1-nodelabelsyncpolicy"
+)
+
+// +kubebuilder:webhook:path=/validate-nodelabel-azure-com-v1alpha1-nodelabelsyncpolicy,mutating=false,failurePolicy=fail,groups=nodelabel.azure.com,resources=nodelabelsyncpolicies,verbs=create;update,versions=v1alpha1,name=vnodelabelsyncpolicy.nodelabel.azure.com
+
+// PolicyValidator rejects a NodeLabelSyncPolicy with settings the controller would not accept
+type PolicyValidator struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &PolicyValidator{}
+
+func (v *PolicyValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var policy v1alpha1.NodeLabelSyncPolicy
+	if err := v.decoder.Decode(req, &policy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := options.ValidatePolicy(&policy); err != nil {
+		return admission.Denied(fmt.Sprintf("invalid NodeLabelSyncPolicy %s: %s", policy.Name, err))
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder is called by the webhook server
+func (v *PolicyValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-nodelabel-azure-com-v1alpha1-nodelabelsyncpolicy,mutating=true,failurePolicy=fail,groups=nodelabel.azure.com,resources=nodelabelsyncpolicies,verbs=create;update,versions=v1alpha1,name=mnodelabelsyncpolicy.nodelabel.azure.com
+
+// PolicyDefaulter fills in unset NodeLabelSyncPolicy fields with their default values
+type PolicyDefaulter struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &PolicyDefaulter{}
+
+func (d *PolicyDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var policy v1alpha1.NodeLabelSyncPolicy
+	if err := d.decoder.Decode(req, &policy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	options.SetPolicyDefaults(&policy.Spec)
+	defaulted, err := json.Marshal(policy)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, defaulted)
+}
+
+// InjectDecoder is called by the webhook server
+func (d *PolicyDefaulter) InjectDecoder(dec *admission.Decoder) error {
+	d.decoder = dec
+	return nil
+}
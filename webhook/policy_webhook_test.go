@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/Azure/node-label-operator/api/v1alpha1"
+)
+
+func TestPolicyValidator(t *testing.T) {
+	var policyValidatorTest = []struct {
+		name     string
+		spec     map[string]interface{}
+		expected bool
+		reason   string
+	}{
+		{
+			"empty spec",
+			map[string]interface{}{},
+			true,
+			"",
+		},
+		{
+			"valid spec",
+			map[string]interface{}{"syncDirection": "two-way", "conflictPolicy": "ignore", "labelPrefix": "", "minSyncPeriod": "1h30m"},
+			true,
+			"",
+		},
+		{
+			"unknown sync direction",
+			map[string]interface{}{"syncDirection": "arm-2-node"},
+			false,
+			`invalid sync direction "arm-2-node"`,
+		},
+		{
+			"unknown conflict policy",
+			map[string]interface{}{"conflictPolicy": "arm-wins"},
+			false,
+			`invalid tag-to-label conflict policy "arm-wins"`,
+		},
+		{
+			"label prefix too long",
+			map[string]interface{}{"labelPrefix": string(make([]byte, 254))},
+			false,
+			"label prefix is over 253 characters",
+		},
+		{
+			"bad duration",
+			map[string]interface{}{"minSyncPeriod": "5 minutes"},
+			false,
+			"invalid min sync period",
+		},
+		{
+			"bad node selector",
+			map[string]interface{}{"nodeSelector": map[string]interface{}{
+				"matchExpressions": []map[string]interface{}{{"key": "pool", "operator": "Near"}},
+			}},
+			false,
+			"invalid node selector",
+		},
+	}
+
+	validator := &PolicyValidator{}
+	assert.NoError(t, validator.InjectDecoder(newDecoder(t)))
+
+	for _, tt := range policyValidatorTest {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := validator.Handle(context.Background(), newPolicyRequest(t, tt.spec))
+			assert.Equal(t, tt.expected, resp.Allowed)
+			if !tt.expected {
+				assert.Contains(t, resp.Result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPolicyDefaulter(t *testing.T) {
+	defaulter := &PolicyDefaulter{}
+	assert.NoError(t, defaulter.InjectDecoder(newDecoder(t)))
+
+	resp := defaulter.Handle(context.Background(), newPolicyRequest(t, map[string]interface{}{"syncDirection": "two-way", "labelPrefix": ""}))
+	assert.True(t, resp.Allowed)
+
+	patched := map[string]bool{}
+	for _, op := range resp.Patches {
+		patched[op.Path] = true
+	}
+	assert.True(t, patched["/spec/tagPrefix"])
+	assert.True(t, patched["/spec/conflictPolicy"])
+	assert.True(t, patched["/spec/minSyncPeriod"])
+	assert.False(t, patched["/spec/syncDirection"])
+	assert.False(t, patched["/spec/labelPrefix"]) // empty prefix is allowed
+}
+
+func newDecoder(t *testing.T) *admission.Decoder {
+	s := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.AddToScheme(s))
+	decoder, err := admission.NewDecoder(s)
+	assert.NoError(t, err)
+	return decoder
+}
+
+func newPolicyRequest(t *testing.T, spec map[string]interface{}) admission.Request {
+	raw, err := json.Marshal(map[string]interface{}{
+		"apiVersion": v1alpha1.GroupVersion.String(),
+		"kind":       "NodeLabelSyncPolicy",
+		"metadata":   map[string]interface{}{"name": "test"},
+		"spec":       spec,
+	})
+	assert.NoError(t, err)
+	return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}